@@ -0,0 +1,263 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"net/http"
+)
+
+// adminPage is the race day admin console served by 'race serve' at /admin.
+// Everything it shows and changes goes through the JSON API.
+var adminPage = `
+<html>
+    <head>
+        <title>Race Admin</title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="/w3.css">
+        <style>
+            .lane { min-height: 2.2em; }
+            .lane.over { background-color: #ffeb3b; }
+            .entry { cursor: move; }
+            .scratched { text-decoration: line-through; color: #999; }
+        </style>
+    </head>
+
+    <body>
+        <div class="w3-bar w3-blue">
+            <span class="w3-bar-item"><b>Race Admin</b></span>
+            <button class="w3-bar-item w3-button tab" data-tab="entries">Entries</button>
+            <button class="w3-bar-item w3-button tab" data-tab="races">Races</button>
+            <button class="w3-bar-item w3-button tab" data-tab="events">Events</button>
+            <button class="w3-bar-item w3-button tab" data-tab="imports">Import Log</button>
+            <input class="w3-bar-item w3-input w3-right" type="password" id="token" placeholder="API token" style="width:12em">
+            <input class="w3-bar-item w3-input w3-right" id="operator" placeholder="Your name" style="width:10em">
+        </div>
+
+        <div id="message" class="w3-container"></div>
+
+        <div id="entries" class="w3-container page">
+            <input class="w3-input w3-margin-top" id="search" placeholder="Search by name, club or bib">
+            <table class="w3-table w3-striped w3-margin-top">
+                <thead><tr><th>Bib</th><th>Name</th><th>Club</th><th>Event</th><th>Race</th><th>Lane</th><th></th></tr></thead>
+                <tbody id="entryRows"></tbody>
+            </table>
+        </div>
+
+        <div id="races" class="w3-container page" style="display:none">
+            <p>Drag an entry onto an empty lane to move it.</p>
+            <div id="raceList" class="w3-row"></div>
+        </div>
+
+        <div id="events" class="w3-container page" style="display:none">
+            <table class="w3-table w3-striped w3-margin-top">
+                <thead><tr><th>Event</th><th>Name</th><th>Start</th><th>Status</th><th></th></tr></thead>
+                <tbody id="eventRows"></tbody>
+            </table>
+        </div>
+
+        <div id="imports" class="w3-container page" style="display:none">
+            <table class="w3-table w3-striped w3-margin-top">
+                <thead><tr><th>When</th><th>Kind</th><th>File</th><th>Added</th><th>Ignored</th><th>Error</th></tr></thead>
+                <tbody id="importRows"></tbody>
+            </table>
+        </div>
+
+        <script>
+            var api = "/api/v1/"
+            var entries = []
+
+            var token = document.getElementById("token")
+            token.value = localStorage.getItem("raceToken") || ""
+            token.onchange = function () { localStorage.setItem("raceToken", token.value) }
+
+            // who makes the changes, for the change log
+            var operator = document.getElementById("operator")
+            operator.value = localStorage.getItem("raceOperator") || ""
+            operator.onchange = function () { localStorage.setItem("raceOperator", operator.value) }
+
+            function show(msg, ok) {
+                var el = document.getElementById("message")
+                el.className = "w3-container w3-padding " + (ok ? "w3-pale-green" : "w3-pale-red")
+                el.textContent = msg
+            }
+
+            function get(path) {
+                return fetch(api + path).then(function (r) { return r.json() })
+            }
+
+            function send(method, path, body) {
+                return fetch(api + path, {
+                    method: method,
+                    headers: {
+                        "Content-Type": "application/json",
+                        "Authorization": "Bearer " + token.value,
+                        "X-Race-Operator": operator.value
+                    },
+                    body: JSON.stringify(body || {})
+                }).then(function (r) {
+                    return r.json().then(function (data) {
+                        if (!r.ok) { throw new Error(data.error) }
+                        return data
+                    })
+                }).catch(function (err) { show(err.message, false); throw err })
+            }
+
+            function cell(row, text, cls) {
+                var td = row.insertCell()
+                td.textContent = text
+                if (cls) { td.className = cls }
+                return td
+            }
+
+            function button(td, label, onclick) {
+                var b = document.createElement("button")
+                b.className = "w3-button w3-small w3-border"
+                b.textContent = label
+                b.onclick = onclick
+                td.appendChild(b)
+            }
+
+            function renderEntries() {
+                var q = document.getElementById("search").value.toLowerCase()
+                var rows = document.getElementById("entryRows")
+                rows.innerHTML = ""
+                entries.filter(function (e) {
+                    return !q || String(e.bib_num) === q ||
+                        e.boat_name.toLowerCase().indexOf(q) >= 0 ||
+                        e.club_abbrev.toLowerCase().indexOf(q) >= 0 ||
+                        e.club_name.toLowerCase().indexOf(q) >= 0
+                }).slice(0, 200).forEach(function (e) {
+                    var row = rows.insertRow()
+                    var cls = e.scratched ? "scratched" : ""
+                    cell(row, e.bib_num, cls)
+                    cell(row, e.boat_name, cls)
+                    cell(row, e.club_abbrev, cls)
+                    cell(row, e.event_id, cls)
+                    cell(row, e.race_id || "", cls)
+                    cell(row, e.lane || "", cls)
+                    button(row.insertCell(), e.scratched ? "Reinstate" : "Scratch", function () {
+                        send("POST", "entries/" + e.bib_num + "/scratch", { scratched: !e.scratched }).then(function () {
+                            show((e.scratched ? "Reinstated " : "Scratched ") + e.boat_name, true)
+                            loadEntries()
+                        })
+                    })
+                })
+            }
+
+            function loadEntries() {
+                get("entries").then(function (data) { entries = data; renderEntries() })
+            }
+
+            function loadRaces() {
+                get("races").then(function (races) {
+                    var list = document.getElementById("raceList")
+                    list.innerHTML = ""
+                    races.forEach(function (race) {
+                        var div = document.createElement("div")
+                        div.className = "w3-col s12 m6 l4 w3-padding"
+                        var head = document.createElement("div")
+                        head.className = "w3-blue w3-padding w3-round"
+                        head.textContent = "Race " + race.id + ", Bank '" + race.bank + "', " + race.start_time + " - " + race.name
+                        button(head, "Publish .RAC", function () {
+                            send("POST", "races/" + race.id + "/publish").then(function (r) {
+                                show("Published " + r.filename, true)
+                            })
+                        })
+                        div.appendChild(head)
+
+                        var byLane = {}
+                        race.lanes.forEach(function (l) { byLane[l.lane] = l })
+                        for (var lane = 1; lane <= (race.nlanes || race.lanes.length); lane++) {
+                            div.appendChild(laneRow(race.id, lane, byLane[lane]))
+                        }
+                        list.appendChild(div)
+                    })
+                })
+            }
+
+            function laneRow(raceID, lane, entry) {
+                var row = document.createElement("div")
+                row.className = "w3-border-bottom lane"
+                row.textContent = lane + ". "
+                if (entry) {
+                    var span = document.createElement("span")
+                    span.className = "entry"
+                    span.draggable = true
+                    span.textContent = entry.boat_name + " (" + entry.club_abbrev + ", bib " + entry.bib_num + ")"
+                    span.ondragstart = function (ev) { ev.dataTransfer.setData("text/plain", entry.bib_num) }
+                    row.appendChild(span)
+                }
+                row.ondragover = function (ev) { ev.preventDefault(); row.classList.add("over") }
+                row.ondragleave = function () { row.classList.remove("over") }
+                row.ondrop = function (ev) {
+                    ev.preventDefault()
+                    row.classList.remove("over")
+                    var bib = ev.dataTransfer.getData("text/plain")
+                    send("PUT", "entries/" + bib + "/lane", { race_id: raceID, lane: lane }).then(function (e) {
+                        show("Moved " + e.boat_name + " to race " + raceID + ", lane " + lane, true)
+                        loadRaces()
+                    })
+                }
+                return row
+            }
+
+            function loadEvents() {
+                get("events").then(function (events) {
+                    var rows = document.getElementById("eventRows")
+                    rows.innerHTML = ""
+                    events.forEach(function (e) {
+                        var row = rows.insertRow()
+                        cell(row, e.id)
+                        cell(row, e.name)
+                        cell(row, e.start)
+                        cell(row, e.official ? "Official" : "Unofficial")
+                        button(row.insertCell(), e.official ? "Mark unofficial" : "Mark official", function () {
+                            send("POST", "events/" + e.id + "/official", { official: !e.official }).then(function () {
+                                show("Event " + e.id + " is " + (e.official ? "unofficial" : "official"), true)
+                                loadEvents()
+                            })
+                        })
+                    })
+                })
+            }
+
+            function loadImports() {
+                get("imports").then(function (imports) {
+                    var rows = document.getElementById("importRows")
+                    rows.innerHTML = ""
+                    ;(imports || []).forEach(function (i) {
+                        var row = rows.insertRow()
+                        cell(row, new Date(i.imported_at).toLocaleString())
+                        cell(row, i.kind)
+                        cell(row, i.filename)
+                        cell(row, i.added)
+                        cell(row, i.ignored)
+                        cell(row, i.error, i.error ? "w3-text-red" : "")
+                    })
+                })
+            }
+
+            var loaders = { entries: loadEntries, races: loadRaces, events: loadEvents, imports: loadImports }
+
+            function openTab(name) {
+                document.querySelectorAll(".page").forEach(function (p) { p.style.display = p.id === name ? "" : "none" })
+                loaders[name]()
+            }
+
+            document.querySelectorAll(".tab").forEach(function (b) {
+                b.onclick = function () { openTab(b.dataset.tab) }
+            })
+            document.getElementById("search").oninput = renderEntries
+
+            openTab("entries")
+        </script>
+    </body>
+</html>
+`
+
+// adminHandler serves the admin console
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(adminPage))
+}
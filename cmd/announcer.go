@@ -0,0 +1,145 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// AnnouncerLane is one lane of a race on the announcer dashboard
+type AnnouncerLane struct {
+	Lane       int
+	BibNum     int
+	BoatName   string
+	ClubName   string
+	ClubAbbrev string
+	EventName  string
+	Ltwt       bool
+
+	// Only set once the race has finished
+	Place  int
+	Time   string
+	Margin string // time behind the winner of the race
+	Record bool   // faster than the event record
+}
+
+// AnnouncerRace is a race on the announcer dashboard
+type AnnouncerRace struct {
+	ID       int
+	Name     string
+	Bank     string
+	Distance uint
+	Start    string
+	Lanes    []AnnouncerLane
+}
+
+// announcerRace converts a scheduled race for the dashboard.  If the race has
+// results, the lanes are sorted by place, otherwise by lane.
+func announcerRace(race ScheduleRace, results model.CurrentResults, events map[int]model.Event) AnnouncerRace {
+	a := AnnouncerRace{
+		ID:       race.ID,
+		Name:     race.Name,
+		Bank:     race.Bank,
+		Distance: race.Distance,
+		Start:    race.Start,
+	}
+
+	entries := append([]model.Entry(nil), race.Entries...)
+	for i := range entries {
+		entries[i].Result, _ = results.InRace(race.ID, entries[i].BibNum)
+	}
+
+	finished := raceFinished(race, results)
+	if finished {
+		model.AssignPlacesToEntries(entries)
+	}
+
+	for _, e := range entries {
+		lane := AnnouncerLane{
+			Lane:       e.Lane,
+			BibNum:     e.BibNum,
+			BoatName:   e.BoatName,
+			ClubName:   e.ClubName,
+			ClubAbbrev: e.ClubAbbrev,
+			EventName:  events[e.EventID].Name,
+			Ltwt:       e.Ltwt,
+		}
+
+		if finished && e.Result.Time != 0 {
+			record := events[e.EventID].Record
+			lane.Place = e.Result.Place
+			lane.Time = durString(e.Result.Time)
+			lane.Margin = "+" + durString(e.Result.Time-entries[0].Result.Time)
+			lane.Record = record != 0 && e.Result.Time < record
+		}
+
+		a.Lanes = append(a.Lanes, lane)
+	}
+
+	return a
+}
+
+// raceFinished reports if the race is completed, or any entry in it has a result
+func raceFinished(race ScheduleRace, results model.CurrentResults) bool {
+	if race.Completed {
+		return true
+	}
+	for _, e := range race.Entries {
+		if result, _ := results.InRace(race.ID, e.BibNum); result.Time != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func announcerPage(db *sqlx.DB) (map[string]interface{}, error) {
+	races, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := model.LoadAllResults(db)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(map[int]model.Event)
+	for _, event := range C.Events {
+		events[event.ID] = event
+	}
+
+	var finished, waiting []ScheduleRace
+	for _, race := range races {
+		if raceFinished(race, results) {
+			finished = append(finished, race)
+		} else if len(race.Entries) > 0 {
+			waiting = append(waiting, race)
+		}
+	}
+
+	data := make(map[string]interface{})
+
+	// The race on the ergs is the first one without results that should
+	// have started by now
+	if len(waiting) > 0 && !waiting[0].StartTime.After(time.Now()) {
+		data["Now"] = announcerRace(waiting[0], results, events)
+		waiting = waiting[1:]
+	}
+
+	var onDeck []AnnouncerRace
+	for i := 0; i < len(waiting) && i < 2; i++ {
+		onDeck = append(onDeck, announcerRace(waiting[i], results, events))
+	}
+	data["OnDeck"] = onDeck
+
+	// The schedule is in start time order, so the latest finished race is the last
+	if len(finished) > 0 {
+		data["Finished"] = announcerRace(finished[len(finished)-1], results, events)
+	}
+
+	return data, nil
+}
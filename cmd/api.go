@@ -0,0 +1,354 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// APIPrefix is the path that version 1 of the JSON API is served under
+const APIPrefix = "/api/v1/"
+
+// operatorHeader says who made an API request, for the change log
+const operatorHeader = "X-Race-Operator"
+
+// api serves the JSON API.  Reads are open to anyone, writes need the
+// configured APIToken.
+type api struct {
+	db *sqlx.DB
+}
+
+// apiHandler returns the handler for every path under APIPrefix
+//
+//	GET  /api/v1/events
+//	POST /api/v1/events/{id}/official    {"official": true}
+//	GET  /api/v1/entries
+//	GET  /api/v1/entries/{bib}
+//	POST /api/v1/entries/{bib}/scratch   {"scratched": true}
+//	PUT  /api/v1/entries/{bib}/lane      {"race_id": 3, "lane": 6}
+//	GET  /api/v1/races
+//	POST /api/v1/races/{id}/publish      {}
+//	GET  /api/v1/results
+//	PUT  /api/v1/results/{bib}           {"time": "7:02.5", "race_id": 3}
+//	GET  /api/v1/imports
+func apiHandler(db *sqlx.DB) http.Handler {
+	a := &api{db: db}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"events", a.get(a.events))
+	mux.HandleFunc(APIPrefix+"events/", a.event)
+	mux.HandleFunc(APIPrefix+"entries", a.get(a.entries))
+	mux.HandleFunc(APIPrefix+"entries/", a.entry)
+	mux.HandleFunc(APIPrefix+"races", a.get(a.races))
+	mux.HandleFunc(APIPrefix+"races/", a.race)
+	mux.HandleFunc(APIPrefix+"results", a.get(a.results))
+	mux.HandleFunc(APIPrefix+"results/", a.result)
+	mux.HandleFunc(APIPrefix+"imports", a.get(a.imports))
+
+	return mux
+}
+
+// apiError is the body of every failed API request
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// authorized reports if the request carries the APIToken.  If there is no
+// APIToken configured, nobody is authorized to write.
+func authorized(r *http.Request) bool {
+	if C.APIToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(C.APIToken)) == 1
+}
+
+// get wraps a read only endpoint
+func (a *api) get(load func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+			return
+		}
+
+		v, err := load()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// write checks that a write request uses the expected method and is
+// authorized, and decodes its body into v
+func (a *api) write(w http.ResponseWriter, r *http.Request, method string, v interface{}) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return false
+	}
+	if !authorized(r) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("a valid API token is required"))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// requestOperator returns who made the request, from its operatorHeader.
+// Requests that don't say are logged as made by "api".
+func requestOperator(r *http.Request) string {
+	if operator := strings.TrimSpace(r.Header.Get(operatorHeader)); operator != "" {
+		return operator
+	}
+	return "api"
+}
+
+// change makes a write in a transaction, so the change log records whoever
+// made the request, not the operator running the server
+func (a *api) change(r *http.Request, fn func(tx *sqlx.Tx) error) error {
+	return model.Transact(a.db, func(tx *sqlx.Tx) error {
+		if err := model.SetOperator(tx, requestOperator(r)); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+func (a *api) events() (interface{}, error) {
+	events, err := LoadResults(a.db)
+	if err != nil {
+		return nil, err
+	}
+	return toExportEvents(events), nil
+}
+
+func (a *api) entries() (interface{}, error) {
+	entries, err := model.LoadEntries(a.db)
+	if err != nil {
+		return nil, err
+	}
+	return toExportEntries(entries), nil
+}
+
+func (a *api) races() (interface{}, error) {
+	return loadExportRaces(a.db)
+}
+
+func (a *api) imports() (interface{}, error) {
+	return model.LoadImports(a.db, 100)
+}
+
+func (a *api) results() (interface{}, error) {
+	events, err := LoadResults(a.db)
+	if err != nil {
+		return nil, err
+	}
+	return toExportResults(events), nil
+}
+
+// idPath splits a path like /api/v1/entries/{bib}/scratch into the bib
+// number (or other id) and the rest of the path
+func idPath(r *http.Request, prefix string) (int, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid id: '%s'", parts[0])
+	}
+	if len(parts) == 1 {
+		return id, "", nil
+	}
+	return id, parts[1], nil
+}
+
+// entry serves /api/v1/entries/{bib} and the writes below it
+func (a *api) entry(w http.ResponseWriter, r *http.Request) {
+	bibNum, action, err := idPath(r, APIPrefix+"entries/")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+			return
+		}
+
+	case "scratch":
+		var body struct {
+			Scratched bool `json:"scratched"`
+		}
+		if !a.write(w, r, http.MethodPost, &body) {
+			return
+		}
+		err := a.change(r, func(tx *sqlx.Tx) error {
+			return ScratchEntry(tx, bibNum, body.Scratched)
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+	case "lane":
+		var body struct {
+			RaceID int `json:"race_id"`
+			Lane   int `json:"lane"`
+		}
+		if !a.write(w, r, http.MethodPut, &body) {
+			return
+		}
+		err := a.change(r, func(tx *sqlx.Tx) error {
+			return MoveEntry(tx, bibNum, body.RaceID, body.Lane)
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown entry action '%s'", action))
+		return
+	}
+
+	// every entry request responds with the current entry
+	entry, err := model.LoadEntryByBib(a.db, bibNum)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toExportEntries([]model.Entry{entry})[0])
+}
+
+// result serves /api/v1/results/{bib}
+func (a *api) result(w http.ResponseWriter, r *http.Request) {
+	bibNum, action, err := idPath(r, APIPrefix+"results/")
+	if err != nil || action != "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown result '%s'", r.URL.Path))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		var body struct {
+			Time   string `json:"time"`
+			RaceID int    `json:"race_id"` // only needed if the bib raced more than once
+		}
+		if !a.write(w, r, http.MethodPut, &body) {
+			return
+		}
+
+		finishTime, err := parseRaceTime(body.Time)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid time: '%s'", body.Time))
+			return
+		}
+		err = a.change(r, func(tx *sqlx.Tx) error {
+			return AdjustResult(tx, bibNum, body.RaceID, finishTime)
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// respond with the placed result, as listed by /results
+	events, err := LoadResults(a.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, result := range toExportResults(events) {
+		if result.BibNum == bibNum {
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("there is no result for bib number %d", bibNum))
+}
+
+// event serves POST /api/v1/events/{id}/official
+func (a *api) event(w http.ResponseWriter, r *http.Request) {
+	eventID, action, err := idPath(r, APIPrefix+"events/")
+	if err != nil || action != "official" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown event action '%s'", r.URL.Path))
+		return
+	}
+
+	var body struct {
+		Official bool `json:"official"`
+	}
+	if !a.write(w, r, http.MethodPost, &body) {
+		return
+	}
+	err = a.change(r, func(tx *sqlx.Tx) error {
+		return CertifyEvent(tx, eventID, body.Official, C.ProtestWindow)
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	events, err := LoadResults(a.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, event := range toExportEvents(events) {
+		if event.ID == eventID {
+			writeJSON(w, http.StatusOK, event)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("there is no event %d", eventID))
+}
+
+// race serves POST /api/v1/races/{id}/publish
+func (a *api) race(w http.ResponseWriter, r *http.Request) {
+	raceID, action, err := idPath(r, APIPrefix+"races/")
+	if err != nil || action != "publish" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown race action '%s'", r.URL.Path))
+		return
+	}
+
+	var body struct{}
+	if !a.write(w, r, http.MethodPost, &body) {
+		return
+	}
+	if err := PublishRace(a.db, raceID); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	race, err := model.LoadRace(a.db, raceID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID       int    `json:"id"`
+		Filename string `json:"filename"`
+	}{race.ID, raceFilename(race)})
+}
@@ -0,0 +1,96 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cjrc/race/model"
+)
+
+func TestAPIMoveEntry(t *testing.T) {
+	db := testDB(t)
+	C.APIToken = "secret"
+
+	var raceID int
+	err := db.Get(&raceID, "INSERT INTO races (regatta_id, name, nlanes) VALUES ($1, 'Race 1', 4) RETURNING id", model.RegattaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bib := range []int{101, 102} {
+		if _, err := (model.Entry{BoatName: "Boat", BibNum: bib}).Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(apiHandler(db))
+	defer server.Close()
+
+	move := func(bib string, token string, body string) (*http.Response, ExportEntry) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, server.URL+APIPrefix+"entries/"+bib+"/lane", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set(operatorHeader, "Jo Referee")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var entry ExportEntry
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, entry
+	}
+
+	body := fmt.Sprintf(`{"race_id": %d, "lane": 2}`, raceID)
+
+	if resp, _ := move("101", "", body); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("move without a token: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := move("101", "wrong", body); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("move with the wrong token: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	resp, entry := move("101", "secret", body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("move: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if entry.BibNum != 101 || entry.RaceID != raceID || entry.Lane != 2 {
+		t.Errorf("move: got bib %d in race %d lane %d, want bib 101 in race %d lane 2",
+			entry.BibNum, entry.RaceID, entry.Lane, raceID)
+	}
+
+	// the move is logged as made by whoever sent the request
+	changes, err := model.LoadChanges(db, model.ChangeFilter{BibNum: 101, Operator: "Jo Referee"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != "UPDATE" {
+		t.Errorf("got %d changes to bib 101 by the request's operator, want the move", len(changes))
+	}
+
+	if resp, _ := move("102", "secret", body); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("move into a taken lane: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if resp, _ := move("999", "secret", body); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("move an unknown bib: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if resp, _ := move("102", "secret", `{"race_id": `); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("move with a bad body: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// archiveVersion is the layout of the archive, restore refuses newer archives
+const archiveVersion = 1
+
+// ArchiveManifest describes an archive, it is the manifest.json in the archive
+type ArchiveManifest struct {
+	Version    int
+	Regatta    Regatta
+	ArchivedAt time.Time
+}
+
+// archiveFolders are the folders in an archive, and where they are in the config
+func archiveFolders(config Config) map[string]string {
+	return map[string]string{
+		"templates": config.TemplatePath,
+		"results":   config.ResultsPath,
+		"races":     config.RacePath,
+	}
+}
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive [FILE]",
+	Short: "Save the whole regatta in one file",
+	Long: `Saves the regatta in one .tar.gz file: race.yaml, the templates, the Venue
+results files, the .RAC files, and every row of the regatta in the database.
+'race restore' recreates the regatta from it, on a backup laptop on race day,
+or for seeding next year's regatta.
+
+The file is named after the regatta and the time, unless FILE is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		slug := pageSlug(C.Regatta.Name)
+		if slug == "" {
+			slug = "regatta"
+		}
+		filename := fmt.Sprintf("%s-%s.tar.gz", slug, time.Now().Format("20060102-1504"))
+		if len(args) > 0 {
+			filename = args[0]
+		}
+
+		if err := writeFileAtomic(filename, writeArchive); err != nil {
+			fmt.Println("Cannot archive the regatta:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Archived the regatta to", filename)
+	},
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:         "restore FILE DIR",
+	Annotations: uncheckedConfig,
+	Short:       "Recreate an archived regatta in a new directory and database",
+	Long: `Recreates the regatta archived by 'race archive' in DIR, which must be new or
+empty.  The database rows are restored to the database given by --db, or else
+the one in the archived race.yaml, and its tables are created unless
+--no-create-tables is given.  Rows keep their ids, so the database must not
+already hold the regatta.
+
+Folders with a relative path in race.yaml are put in DIR.  Folders with an
+absolute path, or outside the regatta's directory, are put in DIR under the
+same path, ie /srv/regatta/results is restored to DIR/srv/regatta/results, and
+race.yaml is rewritten to use them.  Run 'race publish results' in DIR to
+recreate the web pages.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := restoreArchive(args[0], args[1]); err != nil {
+			fmt.Println("Cannot restore the regatta:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Restored the regatta to", args[1])
+	},
+}
+
+var restoreNoCreateTables bool
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().BoolVar(&restoreNoCreateTables, "no-create-tables", false, "Restore into the existing tables of the database")
+}
+
+// writeArchive writes the regatta as a gzipped tar file
+func writeArchive(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(ArchiveManifest{Version: archiveVersion, Regatta: C.Regatta, ArchivedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add("manifest.json", manifest); err != nil {
+		return err
+	}
+
+	// the config file as it is, or the running config if there is no file
+	var config bytes.Buffer
+	if filename := viper.ConfigFileUsed(); filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		config.Write(content)
+	} else if err := C.WriteYAML(&config); err != nil {
+		return err
+	}
+	if err := add("race.yaml", config.Bytes()); err != nil {
+		return err
+	}
+
+	for folder, dir := range archiveFolders(C) {
+		err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, filename)
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			return add(path.Join(folder, filepath.ToSlash(rel)), content)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	tables, err := model.ArchiveRegatta(DBMustConnect())
+	if err != nil {
+		return err
+	}
+	for name, rows := range tables {
+		if err := add(path.Join("db", name+".json"), rows); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readArchive returns the content of every file in the archive
+func readArchive(filename string) (map[string][]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// the names are used as paths, so they must stay inside the regatta
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("invalid file %q in the archive", hdr.Name)
+		}
+
+		if files[name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := files["manifest.json"]; !ok {
+		return nil, fmt.Errorf("%s is not a regatta archive", filename)
+	}
+	return files, nil
+}
+
+// archivedConfig reads the race.yaml from the archive, with the same
+// defaults as every other command
+func archivedConfig(content []byte) (Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	for k, val := range ConfigDefaults {
+		v.SetDefault(k, val)
+	}
+
+	var config Config
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return config, err
+	}
+	err := v.Unmarshal(&config)
+	return config, err
+}
+
+// localPath returns the folder for path in the restored regatta's directory.
+// An absolute path, or one out of the directory, is a folder of the archived
+// regatta, so it is rebased under the directory, and ok is true.
+func localPath(p string) (local string, ok bool) {
+	clean := filepath.Clean(p)
+	up := ".." + string(filepath.Separator)
+	if p == "" || (!filepath.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, up)) {
+		return p, false
+	}
+
+	clean = strings.TrimPrefix(clean, filepath.VolumeName(clean))
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(clean), "/") {
+		if part != "" && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ".", true
+	}
+	return filepath.Join(parts...), true
+}
+
+// restoreFiles makes dir the regatta's directory, with the config, folders
+// and assets of the archive
+func restoreFiles(files map[string][]byte, config Config, dir string) error {
+	// the regatta's folders are relative to its directory, folders of the
+	// archived regatta elsewhere are moved into it
+	rebased := false
+	for _, f := range config.folders() {
+		if local, ok := localPath(*f.path); ok {
+			fmt.Printf("%s %s is outside the regatta, restoring it to %s\n", f.name, *f.path, local)
+			*f.path = local
+			rebased = true
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	C = config
+	model.RegattaID = C.Regatta.ID
+
+	// the archived race.yaml is kept as it is, unless it points elsewhere
+	fmt.Println("restoring config")
+	var err error
+	if dbString != "" || rebased {
+		err = C.WriteToFile("race.yaml")
+	} else {
+		err = ioutil.WriteFile("race.yaml", files["race.yaml"], 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("restoring folders")
+	if err := createFolders(); err != nil {
+		return err
+	}
+	folders := archiveFolders(C)
+	for name, content := range files {
+		parts := strings.SplitN(name, "/", 2)
+		dest, ok := folders[parts[0]]
+		if !ok || len(parts) < 2 {
+			continue
+		}
+
+		filename := filepath.Join(dest, filepath.FromSlash(parts[1]))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+			return err
+		}
+	}
+	return copyAssets()
+}
+
+// restoreArchive recreates the archived regatta in dir
+func restoreArchive(filename string, dir string) error {
+	files, err := readArchive(filename)
+	if err != nil {
+		return err
+	}
+
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return err
+	}
+	if manifest.Version > archiveVersion {
+		return fmt.Errorf("the archive is version %d, this race command only knows version %d", manifest.Version, archiveVersion)
+	}
+
+	config, err := archivedConfig(files["race.yaml"])
+	if err != nil {
+		return err
+	}
+	if dbString != "" {
+		config.DB = dbString
+	}
+	if config.DB == "" {
+		return fmt.Errorf("must specify a database connection to restore the regatta")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	empty, err := isDirEmpty(dir)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("cannot restore a regatta in a non-empty directory")
+	}
+
+	if err := restoreFiles(files, config, dir); err != nil {
+		return err
+	}
+
+	if !restoreNoCreateTables {
+		fmt.Println("creating database schema")
+		if err := createDatabase(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("restoring database rows")
+	tables := make(map[string]json.RawMessage)
+	for name, content := range files {
+		if strings.HasPrefix(name, "db/") && strings.HasSuffix(name, ".json") {
+			tables[strings.TrimSuffix(strings.TrimPrefix(name, "db/"), ".json")] = content
+		}
+	}
+	return model.RestoreRegatta(DBMustConnect(), tables)
+}
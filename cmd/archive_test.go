@@ -0,0 +1,98 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		local string
+		ok    bool
+	}{
+		{"shared/results", "shared/results", false},
+		{"templates", "templates", false},
+		{"./shared/../html", "./shared/../html", false},
+		{"/srv/regatta/results", "srv/regatta/results", true},
+		{"../other/results", "other/results", true},
+		{"shared/../../results", "results", true},
+		{"/", ".", true},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		local, ok := localPath(filepath.FromSlash(tt.path))
+		if local != filepath.FromSlash(tt.local) || ok != tt.ok {
+			t.Errorf("localPath(%q) = %q, %v, want %q, %v", tt.path, local, ok, tt.local, tt.ok)
+		}
+	}
+}
+
+func TestRestoreFilesAbsolutePaths(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, savedDB := C, dbString
+	defer func() {
+		os.Chdir(cwd)
+		C, dbString = saved, savedDB
+	}()
+	dbString = ""
+
+	// the archived regatta's results are in a folder that still exists
+	original := t.TempDir()
+	resultsPath := filepath.Join(original, "results")
+	if err := os.MkdirAll(resultsPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	raceYAML := fmt.Sprintf("db: dbname=regatta\nresultspath: %s\ntemplatepath: templates\n", resultsPath)
+	config, err := archivedConfig([]byte(raceYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"race.yaml":              []byte(raceYAML),
+		"results/race001.txt":    []byte("results"),
+		"templates/results.html": []byte("template"),
+	}
+
+	dir := t.TempDir()
+	if err := restoreFiles(files, config, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(resultsPath, "race001.txt")); !os.IsNotExist(err) {
+		t.Error("the results were restored to the archived regatta's folder")
+	}
+
+	local, _ := localPath(resultsPath)
+	for _, name := range []string{filepath.Join(local, "race001.txt"), filepath.Join("templates", "results.html")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s wasn't restored: %v", name, err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "race.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := archivedConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ResultsPath != local {
+		t.Errorf("race.yaml has ResultsPath %q, want %q", restored.ResultsPath, local)
+	}
+	if restored.TemplatePath != "templates" || restored.DB != "dbname=regatta" {
+		t.Errorf("race.yaml has TemplatePath %q and DB %q, want them unchanged", restored.TemplatePath, restored.DB)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"bytes"
+	"embed"
+	"io"
+	"io/ioutil"
+	"path"
+)
+
+// assets are the stylesheets and scripts the templates use.  They are built
+// into the race binary, so published pages work without an internet connection.
+//
+//go:embed assets
+var assets embed.FS
+
+// copyAssets copies the assets into the HTMLPath, skipping any that are
+// already up to date
+func copyAssets() error {
+	files, err := assets.ReadDir("assets")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		content, err := assets.ReadFile(path.Join("assets", file.Name()))
+		if err != nil {
+			return err
+		}
+
+		filename := path.Join(C.HTMLPath, file.Name())
+		if existing, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(existing, content) {
+			continue
+		}
+
+		err = writeFileAtomic(filename, func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
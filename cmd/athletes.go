@@ -0,0 +1,341 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+var conflictGap time.Duration
+
+// athletesCmd represents the athletes command
+var athletesCmd = &cobra.Command{
+	Use:   "athletes",
+	Short: "Link entries to athletes, and review possible duplicates",
+	Long: `Every entry is linked to an athlete, so one person can be followed across
+events and regattas.  Entries are linked when they are imported, to the athlete
+with the same name and the same date of birth or email.  When that isn't
+enough to be sure, a new athlete is created, and the pair should be reviewed.`,
+}
+
+var athletesLinkCmd = &cobra.Command{
+	Use:   "link",
+	Short: "Link entries that have no athlete",
+	Run: func(cmd *cobra.Command, args []string) {
+		linked, created, ambiguous, err := model.LinkAthletes(DBMustConnect())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Linked %d entries to athletes, created %d new athletes, %d to review.\n",
+			linked, created, ambiguous)
+	},
+}
+
+var athletesReviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "List athletes that might be the same person",
+	Long: `Lists pairs of athletes that have the same name, and might be the same
+person.  Merge them with 'race athletes merge', or mark them as different
+people with 'race athletes distinct'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pairs, err := model.PossibleDuplicates(DBMustConnect())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, p := range pairs {
+			fmt.Printf("%s\n", p.Athlete.Name)
+			for _, a := range []model.Athlete{p.Athlete, p.Other} {
+				fmt.Printf("  #%-5d email: %-30s born: %s\n", a.ID, a.Email, a.DOB)
+			}
+		}
+		if len(pairs) == 0 {
+			fmt.Println("There are no athletes to review.")
+		}
+	},
+}
+
+var athletesMergeCmd = &cobra.Command{
+	Use:   "merge ATHLETE DUPLICATE",
+	Short: "Merge a duplicate into an athlete",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ids := athleteIDs(args)
+		if err := model.MergeAthletes(DBMustConnect(), ids[0], ids[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Merged athlete #%d into #%d.\n", ids[1], ids[0])
+	},
+}
+
+var athletesDistinctCmd = &cobra.Command{
+	Use:   "distinct ATHLETE OTHER",
+	Short: "Mark two athletes as different people",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ids := athleteIDs(args)
+		if err := model.MarkDistinct(DBMustConnect(), ids[0], ids[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var athletesConflictsCmd = &cobra.Command{
+	Use:   "conflicts",
+	Short: "List athletes scheduled in races too close together",
+	Run: func(cmd *cobra.Command, args []string) {
+		conflicts, err := model.ScheduleConflicts(DBMustConnect(), conflictGap)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, c := range conflicts {
+			fmt.Printf("%s (#%d): race %d (bib %d) and race %d (bib %d) start %s apart\n",
+				c.Athlete.Name, c.Athlete.ID, c.First.RaceID, c.First.BibNum,
+				c.Second.RaceID, c.Second.BibNum, c.Gap)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(athletesCmd)
+	athletesCmd.AddCommand(athletesLinkCmd)
+	athletesCmd.AddCommand(athletesReviewCmd)
+	athletesCmd.AddCommand(athletesMergeCmd)
+	athletesCmd.AddCommand(athletesDistinctCmd)
+	athletesCmd.AddCommand(athletesConflictsCmd)
+
+	athletesConflictsCmd.Flags().DurationVar(&conflictGap, "gap", 30*time.Minute, "Races closer together than this are a conflict")
+}
+
+// athleteIDs parses athlete ids from the command line
+func athleteIDs(args []string) []int {
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Println("Invalid athlete id:", arg)
+			os.Exit(1)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// AthleteEntry is one entry on a club or athlete page
+type AthleteEntry struct {
+	BibNum     int
+	EventID    int
+	EventName  string
+	BoatName   string
+	ClubAbbrev string
+	Ltwt       bool
+	Scratched  bool
+	Athlete    string // filename of the athlete page
+
+	// Only set once the entry is scheduled
+	RaceID   int
+	RaceName string
+	Lane     int
+	Start    string
+
+	// "-" until the entry has raced
+	Place string
+	Time  string
+}
+
+// Athlete is everything entered by one athlete, or by one boat from a club
+type Athlete struct {
+	Name       string
+	ClubAbbrev string
+	ClubName   string
+	Page       string
+	Entries    []AthleteEntry
+}
+
+// Club is every entry from one club
+type Club struct {
+	Abbrev  string
+	Name    string
+	Page    string
+	Entries []AthleteEntry
+}
+
+// pageSlug turns a name into something safe to use in a filename
+func pageSlug(name string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, strings.TrimSpace(name))
+
+	for strings.Contains(slug, "--") {
+		slug = strings.Replace(slug, "--", "-", -1)
+	}
+	return strings.Trim(slug, "-")
+}
+
+func clubFilename(abbrev string) string {
+	return fmt.Sprintf("club-%s.html", pageSlug(abbrev))
+}
+
+// athleteFilename is the page of the entry's athlete, or of everything the
+// club entered under the same name if the entry isn't linked to an athlete
+func athleteFilename(e model.Entry) string {
+	if e.AthleteID != 0 {
+		return fmt.Sprintf("athlete-%d-%s.html", e.AthleteID, pageSlug(e.BoatName))
+	}
+	return fmt.Sprintf("athlete-%s-%s.html", pageSlug(e.ClubAbbrev), pageSlug(e.BoatName))
+}
+
+// LoadAthletes groups every entry by club and by athlete.  The events are
+// from LoadResults, so the entries that have raced have their places.
+func LoadAthletes(db *sqlx.DB, events []model.Event) ([]Club, []Athlete, error) {
+	entries, err := model.LoadEntries(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	races, err := model.LoadRaces(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	racesByID := make(map[int]model.Race)
+	for _, race := range races {
+		racesByID[race.ID] = race
+	}
+
+	eventNames := make(map[int]string)
+	placed := make(map[int]model.Entry)
+	for _, event := range events {
+		eventNames[event.ID] = event.Name
+		for _, e := range event.Entries {
+			placed[e.BibNum] = e
+		}
+	}
+
+	// indexes into clubs and athletes
+	clubsByAbbrev := make(map[string]int)
+	athletesByPage := make(map[string]int)
+	var clubs []Club
+	var athletes []Athlete
+
+	for _, e := range entries {
+		a := AthleteEntry{
+			BibNum:     e.BibNum,
+			EventID:    e.EventID,
+			EventName:  eventNames[e.EventID],
+			BoatName:   e.BoatName,
+			ClubAbbrev: e.ClubAbbrev,
+			Ltwt:       e.Ltwt,
+			Scratched:  e.Scratched,
+			Athlete:    athleteFilename(e),
+			Place:      "-",
+			Time:       "-",
+		}
+
+		if race, ok := racesByID[e.RaceID]; ok {
+			a.RaceID = race.ID
+			a.RaceName = race.Name
+			a.Lane = e.Lane
+			a.Start = race.StartTime.In(C.Regatta.Location()).Format("3:04PM")
+		}
+
+		if p, ok := placed[e.BibNum]; ok && p.Result.Time != 0 {
+			a.Time = durString(p.Result.Time)
+			if p.Result.DQ {
+				a.Place = "DQ"
+			} else if p.Result.Place != 0 {
+				a.Place = strconv.Itoa(p.Result.Place)
+			}
+		}
+
+		c, ok := clubsByAbbrev[e.ClubAbbrev]
+		if !ok {
+			c = len(clubs)
+			clubs = append(clubs, Club{Abbrev: e.ClubAbbrev, Name: e.ClubName, Page: clubFilename(e.ClubAbbrev)})
+			clubsByAbbrev[e.ClubAbbrev] = c
+		}
+		clubs[c].Entries = append(clubs[c].Entries, a)
+
+		i, ok := athletesByPage[a.Athlete]
+		if !ok {
+			i = len(athletes)
+			athletes = append(athletes, Athlete{
+				Name:       e.BoatName,
+				ClubAbbrev: e.ClubAbbrev,
+				ClubName:   e.ClubName,
+				Page:       a.Athlete,
+			})
+			athletesByPage[a.Athlete] = i
+		}
+		athletes[i].Entries = append(athletes[i].Entries, a)
+	}
+
+	sort.Slice(clubs, func(h, k int) bool {
+		return clubs[h].Abbrev < clubs[k].Abbrev
+	})
+	sort.Slice(athletes, func(h, k int) bool {
+		return athletes[h].Name < athletes[k].Name
+	})
+
+	for _, club := range clubs {
+		sortAthleteEntries(club.Entries)
+	}
+	for _, athlete := range athletes {
+		sortAthleteEntries(athlete.Entries)
+	}
+
+	return clubs, athletes, nil
+}
+
+// sortAthleteEntries sorts entries by event, then by name
+func sortAthleteEntries(entries []AthleteEntry) {
+	sort.Slice(entries, func(h, k int) bool {
+		if entries[h].EventID != entries[k].EventID {
+			return entries[h].EventID < entries[k].EventID
+		}
+		return entries[h].BoatName < entries[k].BoatName
+	})
+}
+
+// Search is the text an athlete is found by on the index page
+func (athlete Athlete) Search() string {
+	terms := []string{strings.ToLower(athlete.Name), strings.ToLower(athlete.ClubAbbrev)}
+	for _, e := range athlete.Entries {
+		terms = append(terms, strconv.Itoa(e.BibNum))
+	}
+	return strings.Join(terms, " ")
+}
+
+func clubData(club Club) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["Club"] = club
+	return data
+}
+
+func athleteData(athlete Athlete) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["Athlete"] = athlete
+	return data
+}
@@ -0,0 +1,133 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+// publishBibsCmd represents the publish bibs command
+var publishBibsCmd = &cobra.Command{
+	Use:   "bibs",
+	Short: "Publish printable bib number sheets",
+	Long: `Publishes bibs.html, one bib for every entry that has not scratched,
+formatted by the bibs.html template in the TemplatePath.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := PublishBibs(DBMustConnect()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// publishLanecardsCmd represents the publish lanecards command
+var publishLanecardsCmd = &cobra.Command{
+	Use:   "lanecards",
+	Short: "Publish printable lane cards for every erg",
+	Long: `Publishes lanecards.html, one card for every erg (bank and lane) listing
+each race that uses that erg, with the athlete and start time.  The cards are
+formatted by the lanecards.html template in the TemplatePath.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := PublishLanecards(DBMustConnect()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	publishCmd.AddCommand(publishBibsCmd)
+	publishCmd.AddCommand(publishLanecardsCmd)
+}
+
+// LaneCardRace is one race on a LaneCard
+type LaneCardRace struct {
+	RaceID int
+	Start  string
+	Entry  model.Entry
+}
+
+// LaneCard lists every race that uses one erg
+type LaneCard struct {
+	Bank  string
+	Lane  int
+	Races []LaneCardRace
+}
+
+// buildLaneCards returns a card for each erg used by the races, sorted by
+// bank and lane.  Races keep their scheduled order on each card.
+func buildLaneCards(races []ScheduleRace) []LaneCard {
+	var cards []LaneCard
+	index := make(map[string]int)
+
+	for _, race := range races {
+		for _, entry := range race.Entries {
+			key := fmt.Sprintf("%s/%d", race.Bank, entry.Lane)
+			i, ok := index[key]
+			if !ok {
+				i = len(cards)
+				index[key] = i
+				cards = append(cards, LaneCard{Bank: race.Bank, Lane: entry.Lane})
+			}
+			cards[i].Races = append(cards[i].Races, LaneCardRace{RaceID: race.ID, Start: race.Start, Entry: entry})
+		}
+	}
+
+	sort.SliceStable(cards, func(h, k int) bool {
+		if cards[h].Bank != cards[k].Bank {
+			return cards[h].Bank < cards[k].Bank
+		}
+		return cards[h].Lane < cards[k].Lane
+	})
+
+	return cards
+}
+
+func bibsPage(db *sqlx.DB) (map[string]interface{}, error) {
+	entries, err := model.LoadEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var bibs []model.Entry
+	for _, entry := range entries {
+		if entry.Scratched || entry.BibNum == 0 {
+			continue
+		}
+		bibs = append(bibs, entry)
+	}
+
+	data := make(map[string]interface{})
+	data["Entries"] = bibs
+
+	return data, nil
+}
+
+func lanecardsPage(db *sqlx.DB) (map[string]interface{}, error) {
+	races, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{})
+	data["Cards"] = buildLaneCards(races)
+
+	return data, nil
+}
+
+// PublishBibs creates the printable bib sheets in the HTMLPath
+func PublishBibs(db *sqlx.DB) error {
+	return publishPage(db, "bibs.html")
+}
+
+// PublishLanecards creates the printable erg lane cards in the HTMLPath
+func PublishLanecards(db *sqlx.DB) error {
+	return publishPage(db, "lanecards.html")
+}
@@ -0,0 +1,220 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+var certifyEventID int
+var protestWindow time.Duration
+
+// resultsCmd represents the results command
+var resultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Manage the official status and history of results",
+}
+
+// certifyCmd represents the results certify command
+var certifyCmd = &cobra.Command{
+	Use:   "certify",
+	Short: "Certify an event's results as official",
+	Long: `Certifies the results of an event as official.  Certified results are
+locked, so they are ignored by 'race import results' and cannot be adjusted,
+and the published results show when they became official.  An event with a
+protest that hasn't been closed cannot be certified.
+
+If there is a protest window (--protest-window, or ProtestWindow in the config),
+results can't be certified until the window has passed since the last of the
+event's results was imported, and the command waits for what is left of it.
+Press Ctrl-C to cancel.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("protest-window") {
+			protestWindow = C.ProtestWindow
+		}
+
+		db := DBMustConnect()
+		if err := waitForProtests(db, certifyEventID, protestWindow); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := CertifyEvent(db, certifyEventID, true, protestWindow); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// uncertifyCmd represents the results uncertify command
+var uncertifyCmd = &cobra.Command{
+	Use:   "uncertify",
+	Short: "Return an event's results to unofficial",
+	Long:  `Unlocks the results of a certified event, so they can be corrected.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := CertifyEvent(DBMustConnect(), certifyEventID, false, 0); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// resultsHistoryCmd represents the results history command
+var resultsHistoryCmd = &cobra.Command{
+	Use:   "history BIB",
+	Short: "List every version of a bib's result",
+	Long: `Lists every result imported for a bib, oldest first.  Corrected results
+supersede the earlier ones, which are kept, and marked with when they were
+replaced.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bib, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Invalid bib number:", args[0])
+			os.Exit(1)
+		}
+
+		versions, err := model.LoadResultVersions(DBMustConnect(), bib)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(versions) == 0 {
+			fmt.Printf("Bib %d has no results.\n", bib)
+			return
+		}
+
+		loc := C.Regatta.Location()
+		for _, result := range versions {
+			status := "current"
+			if result.SupersededAt != nil {
+				status = "replaced " + result.SupersededAt.In(loc).Format("Jan 2 15:04:05")
+			}
+			fmt.Printf("v%d  imported %s, %s\n", result.Version, result.ImportedAt.In(loc).Format("Jan 2 15:04:05"), status)
+			fmt.Printf("    %s\n", describeResult(result))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resultsCmd)
+	resultsCmd.AddCommand(certifyCmd)
+	resultsCmd.AddCommand(uncertifyCmd)
+	resultsCmd.AddCommand(resultsHistoryCmd)
+
+	for _, c := range []*cobra.Command{certifyCmd, uncertifyCmd} {
+		c.Flags().IntVar(&certifyEventID, "event", 0, "Event number")
+		c.MarkFlagRequired("event")
+	}
+	certifyCmd.Flags().DurationVar(&protestWindow, "protest-window", 0, "Wait this long for protests before certifying")
+}
+
+// protestWindowCloses returns when the protest window of the event closes,
+// which is the window after the last of its results was imported
+func protestWindowCloses(db model.DB, event model.Event, window time.Duration) (time.Time, error) {
+	completed, err := event.FirstCompleted(db)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if completed.IsZero() {
+		return time.Time{}, fmt.Errorf("event %d (%s) has no results, its protest window hasn't opened", event.ID, event.Name)
+	}
+	return completed.Add(window), nil
+}
+
+// waitForProtests counts down what is left of the protest window before an
+// event is certified
+func waitForProtests(db *sqlx.DB, eventID int, window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+
+	event, err := findEvent(eventID)
+	if err != nil {
+		return err
+	}
+	deadline, err := protestWindowCloses(db, event, window)
+	if err != nil {
+		return err
+	}
+	if !time.Now().Before(deadline) {
+		return nil
+	}
+
+	fmt.Printf("Protest window for event %d (%s) is open. Press Ctrl-C to cancel.\n", event.ID, event.Name)
+
+	for left := time.Until(deadline); left > 0; left = time.Until(deadline) {
+		fmt.Printf("  %s until results are certified\n", left.Round(time.Second))
+		if left > time.Minute {
+			left = time.Minute
+		}
+		time.Sleep(left)
+	}
+
+	return nil
+}
+
+// CertifyEvent certifies the results of an event as official, or returns
+// them to unofficial.  Every change is recorded in the Certifications table.
+// An event can't be certified while it is under protest, or until its
+// protest window has closed.
+func CertifyEvent(db model.DB, eventID int, certified bool, window time.Duration) error {
+	event, err := findEvent(eventID)
+	if err != nil {
+		return err
+	}
+
+	err = model.Transact(db, func(tx *sqlx.Tx) error {
+		// so a protest can't be filed while the event is being certified
+		if err := event.Lock(tx); err != nil {
+			return err
+		}
+
+		if certified {
+			if window > 0 {
+				closes, err := protestWindowCloses(tx, event, window)
+				if err != nil {
+					return err
+				}
+				if time.Now().Before(closes) {
+					return fmt.Errorf("the protest window for event %d (%s) is open until %s",
+						event.ID, event.Name, closes.In(C.Regatta.Location()).Format("15:04:05"))
+				}
+			}
+
+			protested, err := model.EventsUnderProtest(tx)
+			if err != nil {
+				return err
+			}
+			if protested[event.ID] {
+				return fmt.Errorf("event %d (%s) is under protest, and cannot be certified", event.ID, event.Name)
+			}
+		}
+
+		if err := event.SetOfficial(tx, certified); err != nil {
+			return err
+		}
+
+		cert := model.Certification{EventID: event.ID, Certified: certified}
+		return cert.Insert(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	if certified {
+		fmt.Printf("Results for event %d (%s) are official.\n", event.ID, event.Name)
+	} else {
+		fmt.Printf("Results for event %d (%s) are unofficial.\n", event.ID, event.Name)
+	}
+
+	return model.NotifyResults(db)
+}
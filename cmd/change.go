@@ -0,0 +1,178 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/spf13/cobra"
+)
+
+var unscratch bool
+var adjustRaceID int
+
+// scratchCmd represents the scratch command
+var scratchCmd = &cobra.Command{
+	Use:   "scratch BIB",
+	Short: "Scratch an entry from the regatta",
+	Long: `Marks the entry with the specified bib number as scratched.  A scratched
+entry is left out of the schedule and start lists, but keeps its race and
+lane, so 'race scratch --undo' will put it back.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bibNum, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Invalid bib number:", args[0])
+			os.Exit(1)
+		}
+
+		if err := ScratchEntry(DBMustConnect(), bibNum, !unscratch); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// moveCmd represents the move command
+var moveCmd = &cobra.Command{
+	Use:   "move BIB RACE LANE",
+	Short: "Move an entry to a lane in a race",
+	Long:  `Moves the entry with the specified bib number into an empty lane of a race.`,
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		var nums [3]int
+		for i, arg := range args {
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Println("Invalid number:", arg)
+				os.Exit(1)
+			}
+			nums[i] = n
+		}
+
+		if err := MoveEntry(DBMustConnect(), nums[0], nums[1], nums[2]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// adjustCmd represents the adjust command
+var adjustCmd = &cobra.Command{
+	Use:   "adjust BIB TIME",
+	Short: "Correct the finishing time of a result",
+	Long: `Replaces the finishing time of the result for the specified bib number.
+TIME is formatted like the Venue results, ie 7:02.5.  If the bib has results in
+more than one race, --race says which one to correct.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		bibNum, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Invalid bib number:", args[0])
+			os.Exit(1)
+		}
+
+		finishTime, err := parseRaceTime(args[1])
+		if err != nil {
+			fmt.Println("Invalid time:", args[1])
+			os.Exit(1)
+		}
+
+		if err := AdjustResult(DBMustConnect(), bibNum, adjustRaceID, finishTime); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scratchCmd)
+	rootCmd.AddCommand(moveCmd)
+	rootCmd.AddCommand(adjustCmd)
+
+	scratchCmd.Flags().BoolVar(&unscratch, "undo", false, "Reinstate a scratched entry")
+	adjustCmd.Flags().IntVar(&adjustRaceID, "race", 0, "Race of the result, if the bib raced more than once")
+}
+
+// parseRaceTime parses times formatted like the Venue results, ie 7:02.5
+func parseRaceTime(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.Replace(strings.TrimSpace(s), ":", "m", 1) + "s")
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid race time '%s', it must be after the start", s)
+	}
+	return d, nil
+}
+
+// ScratchEntry scratches (or reinstates) the entry with the specified bib number
+func ScratchEntry(db model.DB, bibNum int, scratched bool) error {
+	entry, err := model.LoadEntryByBib(db, bibNum)
+	if err != nil {
+		return err
+	}
+
+	if err := entry.Scratch(db, scratched); err != nil {
+		return err
+	}
+
+	if scratched {
+		fmt.Printf("Scratched %s (bib # %d).\n", entry.BoatName, entry.BibNum)
+	} else {
+		fmt.Printf("Reinstated %s (bib # %d).\n", entry.BoatName, entry.BibNum)
+	}
+
+	return model.NotifyEntries(db)
+}
+
+// MoveEntry moves the entry with the specified bib number to a lane in a race
+func MoveEntry(db model.DB, bibNum int, raceID int, lane int) error {
+	entry, err := model.LoadEntryByBib(db, bibNum)
+	if err != nil {
+		return err
+	}
+
+	if err := entry.MoveToLane(db, raceID, lane); err != nil {
+		return err
+	}
+
+	fmt.Printf("Moved %s (bib # %d) to race %d, lane %d.\n", entry.BoatName, entry.BibNum, raceID, lane)
+
+	return model.NotifyEntries(db)
+}
+
+// AdjustResult corrects the finishing time of the result for the specified bib
+// number in the race.  A raceID of 0 corrects the bib's only result.
+func AdjustResult(db model.DB, bibNum int, raceID int, finishTime time.Duration) error {
+	result, err := model.LoadResultByBib(db, bibNum, raceID)
+	if err != nil {
+		return err
+	}
+
+	old := result.Time
+	if err := result.SetTime(db, finishTime); err != nil {
+		return err
+	}
+
+	fmt.Printf("Adjusted time for %s (bib # %d) from %s to %s.\n",
+		result.Name, result.BibNum, durString(old), durString(finishTime))
+
+	return model.NotifyResults(db)
+}
+
+// findEvent returns the configured event with the specified id
+func findEvent(eventID int) (model.Event, error) {
+	for _, event := range C.Events {
+		if event.ID == eventID {
+			return event, nil
+		}
+	}
+	return model.Event{}, fmt.Errorf("there is no event %d", eventID)
+}
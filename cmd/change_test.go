@@ -0,0 +1,35 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRaceTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"7:02.5", 7*time.Minute + 2500*time.Millisecond, true},
+		{" 0:59.9 ", 59900 * time.Millisecond, true},
+		{"12:00", 12 * time.Minute, true},
+		{"0:00.0", 0, false},
+		{"-7:02.5", 0, false},
+		{"7:xx", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRaceTime(tt.s)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseRaceTime(%q): got %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseRaceTime(%q): got %v, want an error", tt.s, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/spf13/cobra"
+)
+
+var changeFilter model.ChangeFilter
+var changesSince time.Duration
+
+// logCmd represents the log command
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show who changed entries, results and races",
+	Long: `Lists the changes to entries, results and races, newest first.  Every
+insert, update and delete is recorded by the database, with who made it (the
+Operator in the config, or the OS user, or for changes made through 'race serve'
+the operator named in the API request), when, which command, and the values
+before and after.
+
+  race log --bib 112            who moved, scratched or adjusted bib 112
+  race log --race 7 --since 1h  what changed in race 7 in the last hour`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if changesSince > 0 {
+			changeFilter.Since = time.Now().Add(-changesSince)
+		}
+
+		changes, err := model.LoadChanges(DBMustConnect(), changeFilter)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		loc := C.Regatta.Location()
+		for _, c := range changes {
+			fmt.Printf("%s  %-10s %-22s %-7s %s %s\n", c.ChangedAt.In(loc).Format("Jan 2 15:04:05"),
+				c.Operator, c.Command, strings.ToLower(c.Action), changeSubject(c), changeDetail(c))
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("No changes.")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+
+	logCmd.Flags().StringVar(&changeFilter.Table, "table", "", "Only show changes to entries, results or races")
+	logCmd.Flags().IntVar(&changeFilter.BibNum, "bib", 0, "Only show changes to this bib's entry and results")
+	logCmd.Flags().IntVar(&changeFilter.RaceID, "race", 0, "Only show changes to this race, and the entries in it")
+	logCmd.Flags().StringVar(&changeFilter.Operator, "operator", "", "Only show changes made by this operator")
+	logCmd.Flags().DurationVar(&changesSince, "since", 0, "Only show changes made in this long, like 2h")
+	logCmd.Flags().IntVar(&changeFilter.Limit, "limit", 50, "Show at most this many changes, 0 for all")
+}
+
+// changeRows decodes the row before and after the change
+func changeRows(c model.Change) (before map[string]interface{}, after map[string]interface{}) {
+	json.Unmarshal(c.Before, &before)
+	json.Unmarshal(c.After, &after)
+	return
+}
+
+// changeSubject is what was changed, like "entry bib 112" or "race 7"
+func changeSubject(c model.Change) string {
+	before, after := changeRows(c)
+	row := after
+	if row == nil {
+		row = before
+	}
+
+	switch c.TableName {
+	case "races":
+		return fmt.Sprintf("race %v", row["id"])
+	case "results":
+		return fmt.Sprintf("result bib %v", row["bib_num"])
+	default:
+		return fmt.Sprintf("entry bib %v", row["bib_num"])
+	}
+}
+
+// changeDetail lists the values that changed, or the values of an inserted or
+// deleted row.  Times are stored as nanoseconds, and shown as durations.
+func changeDetail(c model.Change) string {
+	before, after := changeRows(c)
+
+	value := func(row map[string]interface{}, col string) string {
+		v := row[col]
+		if n, ok := v.(float64); ok && (col == "time" || col == "avg_pace" || col == "seed") {
+			return time.Duration(n).String()
+		}
+		if s, ok := v.(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+		return fmt.Sprint(v)
+	}
+
+	var cols []string
+	switch {
+	case before != nil && after != nil:
+		for col := range after {
+			if fmt.Sprint(before[col]) != fmt.Sprint(after[col]) {
+				cols = append(cols, col)
+			}
+		}
+	case after != nil:
+		for col := range after {
+			cols = append(cols, col)
+		}
+	default:
+		for col := range before {
+			cols = append(cols, col)
+		}
+	}
+	sort.Strings(cols)
+
+	var parts []string
+	for _, col := range cols {
+		if col == "id" || col == "regatta_id" {
+			continue
+		}
+		switch {
+		case before != nil && after != nil:
+			parts = append(parts, fmt.Sprintf("%s %s -> %s", col, value(before, col), value(after, col)))
+		case after != nil:
+			parts = append(parts, fmt.Sprintf("%s=%s", col, value(after, col)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%s", col, value(before, col)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
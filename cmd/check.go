@@ -5,6 +5,9 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+
+	"github.com/extrame/xls"
 
 	"github.com/spf13/cobra"
 )
@@ -40,9 +43,39 @@ to quickly create a Cobra application.`,
 	},
 }
 
+var checkConfigCmd = &cobra.Command{
+	Use:         "config",
+	Short:       "Check the config for mistakes",
+	Annotations: uncheckedConfig,
+	Long: `Checks the whole config, and lists every problem.  Every other command runs
+the same checks when it starts, and stops if there are any.  The EntryCols are
+also checked against the entries file (--file), if there is one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errs := C.Validate()
+
+		if workbook, err := xls.Open(EntriesFilename, "utf-8"); err == nil {
+			if rows := workbook.ReadAllCells(1); len(rows) > 0 {
+				errs = append(errs, C.checkEntryWidth(len(rows[0]))...)
+			}
+		}
+
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) > 0 {
+			fmt.Printf("%d problems.\n", len(errs))
+			os.Exit(1)
+		}
+		fmt.Println("The config is good!")
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(checkCmd)
 	checkCmd.AddCommand(checkDatabaseCmd)
+	checkCmd.AddCommand(checkConfigCmd)
+
+	checkConfigCmd.Flags().StringVar(&EntriesFilename, "file", EntriesFilename, "Path to Excel file from Regatta Central")
 
 	// Here you will define your flags and configuration settings.
 
@@ -6,7 +6,11 @@ package cmd
 import (
 	"fmt"
 	"io"
+	"net"
+	"net/mail"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cjrc/race/model"
@@ -17,16 +21,55 @@ import (
 // WriteGo is a flaf that determines if config should be dumped as GoLang or YAML
 var WriteGo bool
 
+// Regatta describes the regatta on the published pages
+type Regatta struct {
+	ID       int // in the Regattas table, every command works on this regatta's data
+	Name     string
+	Date     string
+	Venue    string
+	Logo     string // URL of the logo, or a filename in the HTMLPath
+	TimeZone string // IANA time zone, like America/New_York, times are shown in this zone
+	Contact  string
+}
+
+// Location returns the regatta's time zone, or the local time zone if it isn't set
+func (regatta Regatta) Location() *time.Location {
+	// LoadLocation("") is UTC, not the local time zone
+	if regatta.TimeZone == "" {
+		return time.Local
+	}
+	if loc, err := time.LoadLocation(regatta.TimeZone); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// SMTP is the mail server that 'race notify' sends email through
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string // no authentication if empty
+	Password string
+	From     string // the sender of every email
+}
+
 // Config represents the global configuration
 type Config struct {
+	Regatta Regatta
+	SMTP    SMTP
+
 	NLanes int // number of ergs per bank
 
 	DB string `mapstructure:"DB"`
 
+	Operator string // who is recorded in the change log, the OS user if empty
+
 	HTMLPath     string
 	RacePath     string
 	ResultsPath  string
 	TemplatePath string
+	ExportPath   string // JSON and CSV exports are written here
+	OutboxPath   string // 'race notify --dry-run' writes the emails here
 
 	// For creating the schedule
 	RaceDuration time.Duration // How long each race will take in the schedule
@@ -34,20 +77,51 @@ type Config struct {
 
 	EntryCols struct { // Column numbers for the RegattaCentral generic boats.xls file
 		EventID, BoatID, Age, Email, ClubName, ClubAbbrev, Seed, BoatName, Country int
+
+		DOB int // -1 if the file has no date of birth
 	}
 
 	MaxEntries int // Maximum number of lines that will be read from entries.xls
 
+	// For 'race import results --live'
+	ResultsSettle  time.Duration // A results file must be unchanged this long before it is imported
+	QuarantinePath string        // Results files that can't be read are moved here
+
+	ProtestWindow time.Duration // How long to wait for protests before results are certified
+
+	ServeAddr string // Address the 'race serve' web server listens on
+	APIToken  string // Token needed to write through the JSON API, writes are disabled if empty
+
+	// For the venue display
+	DisplayDwell  time.Duration // How long each slide is shown
+	DisplayEvents int           // How many of the most recently completed events are shown
+	TeamPoints    []int         // Points scored by 1st, 2nd, 3rd... place for the team standings
+
 	Events []model.Event // The events in this regatta
 }
 
 // ConfigDefaults are passed to Viper to set the default config values
 var ConfigDefaults = map[string]interface{}{
 	"DB":                   "",
+	"Operator":             "",
+	"Regatta.ID":           0,
+	"Regatta.Name":         "",
+	"Regatta.Date":         "",
+	"Regatta.Venue":        "",
+	"Regatta.Logo":         "",
+	"Regatta.TimeZone":     "",
+	"Regatta.Contact":      "",
+	"SMTP.Host":            "localhost",
+	"SMTP.Port":            25,
+	"SMTP.Username":        "",
+	"SMTP.Password":        "",
+	"SMTP.From":            "",
 	"HTMLPath":             "shared/html",
 	"RacePath":             "shared/races",
 	"ResultsPath":          "shared/results",
 	"TemplatePath":         "templates",
+	"ExportPath":           "shared/export",
+	"OutboxPath":           "shared/outbox",
 	"EntryCols.EventID":    0,
 	"EntryCols.BoatID":     10,
 	"EntryCols.Age":        12,
@@ -57,7 +131,17 @@ var ConfigDefaults = map[string]interface{}{
 	"EntryCols.Seed":       11,
 	"EntryCols.BoatName":   14,
 	"EntryCols.Country":    24,
+	"EntryCols.DOB":        -1,
 	"MaxEntries":           2000,
+	"ResultsSettle":        2 * time.Second,
+	"QuarantinePath":       "shared/quarantine",
+	"ProtestWindow":        time.Duration(0),
+	"ServeAddr":            ":8080",
+	"APIToken":             "",
+	"DisplayDwell":         10 * time.Second,
+	"DisplayEvents":        5,
+	"TeamPoints":           []int{10, 8, 6, 5, 4, 3, 2, 1},
+	"NLanes":               12,
 	"SeedOrder":            []int{6, 7, 5, 8, 4, 9, 3, 10, 2, 11, 1, 12},
 	"RaceDuration":         15 * time.Minute,
 	"Events": []model.Event{
@@ -89,10 +173,179 @@ var ConfigDefaults = map[string]interface{}{
 // C contains global configuration
 var C Config
 
+// eventStartLayout is the format of an Event's Start, like 8:00AM
+const eventStartLayout = "3:04PM"
+
+// entryColumn is one of the EntryCols, by name for error messages
+type entryColumn struct {
+	Name string
+	Col  int
+}
+
+// entryColumns returns the EntryCols by name.  DOB is left out if the entries
+// file has no date of birth.
+func (config Config) entryColumns() []entryColumn {
+	cols := config.EntryCols
+	columns := []entryColumn{
+		{"EventID", cols.EventID}, {"BoatID", cols.BoatID}, {"Age", cols.Age},
+		{"Email", cols.Email}, {"ClubName", cols.ClubName}, {"ClubAbbrev", cols.ClubAbbrev},
+		{"Seed", cols.Seed}, {"BoatName", cols.BoatName}, {"Country", cols.Country},
+	}
+	if cols.DOB >= 0 {
+		columns = append(columns, entryColumn{"DOB", cols.DOB})
+	}
+	return columns
+}
+
+// checkEntryWidth reports the EntryCols that are beyond the width of the
+// entries file, which has width columns
+func (config Config) checkEntryWidth(width int) []error {
+	var errs []error
+	for _, c := range config.entryColumns() {
+		if c.Col >= width {
+			errs = append(errs, fmt.Errorf("EntryCols.%s is column %d, but the entries file only has columns 0 to %d", c.Name, c.Col, width-1))
+		}
+	}
+	return errs
+}
+
+// configFolder is a folder setting of the config
+type configFolder struct {
+	name string
+	path *string
+}
+
+// folders are the folder settings of the config
+func (config *Config) folders() []configFolder {
+	return []configFolder{
+		{"HTMLPath", &config.HTMLPath}, {"RacePath", &config.RacePath}, {"ResultsPath", &config.ResultsPath},
+		{"TemplatePath", &config.TemplatePath}, {"ExportPath", &config.ExportPath},
+		{"OutboxPath", &config.OutboxPath}, {"QuarantinePath", &config.QuarantinePath},
+	}
+}
+
+// Validate checks the whole config, and returns every problem found.  Every
+// command runs it on startup, except those that create or show the config.
+func (config Config) Validate() []error {
+	var errs []error
+	problem := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if config.Regatta.TimeZone != "" {
+		if _, err := time.LoadLocation(config.Regatta.TimeZone); err != nil {
+			problem("Regatta.TimeZone %q is not a time zone, use a name like America/New_York", config.Regatta.TimeZone)
+		}
+	}
+
+	for _, f := range config.folders() {
+		if *f.path == "" {
+			problem("%s is empty, set it to a folder", f.name)
+		}
+	}
+	if config.QuarantinePath != "" && filepath.Clean(config.QuarantinePath) == filepath.Clean(config.ResultsPath) {
+		problem("QuarantinePath is the ResultsPath, bad results files would be read again")
+	}
+
+	// the scheduler leaves two lanes of every race empty
+	if config.NLanes < 3 {
+		problem("NLanes is %d, it must be the number of ergs in a bank, at least 3", config.NLanes)
+	} else {
+		if len(config.SeedOrder) < config.NLanes {
+			problem("SeedOrder has %d lanes, but NLanes is %d: list every lane from 1 to %d", len(config.SeedOrder), config.NLanes, config.NLanes)
+		}
+		seeded := make(map[int]bool)
+		for i, lane := range config.SeedOrder {
+			if i >= config.NLanes {
+				break
+			}
+			if lane < 1 || lane > config.NLanes {
+				problem("SeedOrder has lane %d, lanes are 1 to %d (NLanes)", lane, config.NLanes)
+			} else if seeded[lane] {
+				problem("SeedOrder has lane %d twice", lane)
+			}
+			seeded[lane] = true
+		}
+	}
+	if config.RaceDuration <= 0 {
+		problem("RaceDuration is %v, set it to how long each race takes, like 15m", config.RaceDuration)
+	}
+
+	if len(config.Events) == 0 {
+		problem("there are no Events")
+	}
+	events := make(map[int]bool)
+	for i, e := range config.Events {
+		if e.ID < 1 {
+			problem("Events[%d] (%s) has ID %d, event IDs must be 1 or more", i, e.Name, e.ID)
+		} else if events[e.ID] {
+			problem("event %d is in Events twice, event IDs must be unique", e.ID)
+		}
+		events[e.ID] = true
+
+		if strings.TrimSpace(e.Name) == "" {
+			problem("event %d has no Name", e.ID)
+		}
+		if _, err := time.Parse(eventStartLayout, e.Start); err != nil {
+			problem("event %d has Start %q, use a time like 8:00AM", e.ID, e.Start)
+		}
+		if e.Distance == 0 {
+			problem("event %d has no Distance, set it in meters", e.ID)
+		}
+		if e.Bank == "" {
+			problem("event %d has no Bank", e.ID)
+		}
+	}
+
+	columns := make(map[int]string)
+	for _, c := range config.entryColumns() {
+		if c.Col < 0 {
+			problem("EntryCols.%s is %d, columns are numbered from 0", c.Name, c.Col)
+			continue
+		}
+		if other, ok := columns[c.Col]; ok {
+			problem("EntryCols.%s and EntryCols.%s are both column %d", other, c.Name, c.Col)
+		}
+		columns[c.Col] = c.Name
+	}
+	if config.MaxEntries < 2 {
+		problem("MaxEntries is %d, it must cover the header row and every entry", config.MaxEntries)
+	}
+
+	if config.ResultsSettle < 0 {
+		problem("ResultsSettle is %v, it can't be negative", config.ResultsSettle)
+	}
+	if config.ProtestWindow < 0 {
+		problem("ProtestWindow is %v, it can't be negative", config.ProtestWindow)
+	}
+
+	if _, _, err := net.SplitHostPort(config.ServeAddr); err != nil {
+		problem("ServeAddr %q is not an address, use host:port or :port, like :8080", config.ServeAddr)
+	}
+	if config.SMTP.Port < 1 || config.SMTP.Port > 65535 {
+		problem("SMTP.Port is %d, use the mail server's port, like 25 or 587", config.SMTP.Port)
+	}
+	if config.SMTP.From != "" {
+		if _, err := mail.ParseAddress(config.SMTP.From); err != nil {
+			problem("SMTP.From %q is not an email address", config.SMTP.From)
+		}
+	}
+
+	if config.DisplayDwell <= 0 {
+		problem("DisplayDwell is %v, set it to how long each slide is shown, like 10s", config.DisplayDwell)
+	}
+	if config.DisplayEvents < 1 {
+		problem("DisplayEvents is %d, it must be 1 or more", config.DisplayEvents)
+	}
+
+	return errs
+}
+
 // configCmd represents the config command
 var configCmd = &cobra.Command{
-	Use:   "config",
-	Short: "Dumps the current configuration to stdout",
+	Use:         "config",
+	Annotations: uncheckedConfig,
+	Short:       "Dumps the current configuration to stdout",
 	Long: `A longer description that spans multiple lines and likely contains examples
 and usage of using your command. For example:
 
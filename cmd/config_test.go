@@ -0,0 +1,28 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegattaLocation(t *testing.T) {
+	tests := []struct {
+		timeZone string
+		want     string
+	}{
+		{"", time.Local.String()},
+		{"America/New_York", "America/New_York"},
+		{"UTC", "UTC"},
+		{"Not/A_Zone", time.Local.String()},
+	}
+
+	for _, tt := range tests {
+		got := Regatta{TimeZone: tt.timeZone}.Location()
+		if got.String() != tt.want {
+			t.Errorf("Location of %q: got %s, want %s", tt.timeZone, got, tt.want)
+		}
+	}
+}
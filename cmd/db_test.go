@@ -0,0 +1,35 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/cjrc/race/internal/testdb"
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDB creates the regatta's tables in a schema of its own in the Postgres
+// database in RACE_TEST_DB, and points the config at it.  Tests that need a
+// database are skipped when RACE_TEST_DB isn't set.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	saved := C
+	t.Cleanup(func() { C = saved })
+	C.DB = testdb.DSN(t)
+	C.Regatta.ID = 1
+	model.RegattaID = 1
+
+	if err := createDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
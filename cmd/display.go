@@ -0,0 +1,76 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"sort"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// TeamStanding is one club's total in the team standings
+type TeamStanding struct {
+	ClubAbbrev string
+	ClubName   string
+	Points     int
+}
+
+// TeamStandings totals the points scored by each club, using the configured
+// TeamPoints for each place.  Clubs are sorted by points, most first.
+func TeamStandings(events []model.Event) []TeamStanding {
+	var standings []TeamStanding
+	index := make(map[string]int)
+
+	for _, event := range events {
+		for _, e := range event.Entries {
+			place := e.Result.Place
+			if e.Result.Time == 0 || place < 1 || place > len(C.TeamPoints) || e.ClubAbbrev == "" {
+				continue
+			}
+
+			i, ok := index[e.ClubAbbrev]
+			if !ok {
+				i = len(standings)
+				index[e.ClubAbbrev] = i
+				standings = append(standings, TeamStanding{ClubAbbrev: e.ClubAbbrev, ClubName: e.ClubName})
+			}
+			standings[i].Points += C.TeamPoints[place-1]
+		}
+	}
+
+	sort.SliceStable(standings, func(h, k int) bool {
+		return standings[h].Points > standings[k].Points
+	})
+
+	return standings
+}
+
+func displayPage(db *sqlx.DB) (map[string]interface{}, error) {
+	events, err := LoadResults(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// The most recently completed events come first
+	var completed []model.Event
+	for _, event := range events {
+		if !event.Completed().IsZero() {
+			completed = append(completed, event)
+		}
+	}
+	sort.SliceStable(completed, func(h, k int) bool {
+		return completed[h].Completed().After(completed[k].Completed())
+	})
+	if len(completed) > C.DisplayEvents {
+		completed = completed[:C.DisplayEvents]
+	}
+
+	data := make(map[string]interface{})
+	data["Events"] = completed
+	data["Standings"] = TeamStandings(events)
+	data["Dwell"] = C.DisplayDwell.Nanoseconds() / 1e6
+
+	return data, nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/cjrc/race/model"
 	"github.com/extrame/xls"
+	"github.com/jmoiron/sqlx"
 	"github.com/spf13/cobra"
 )
 
@@ -28,9 +29,9 @@ downloaded from RegattaCentral as "Generic Boats".`,
 	},
 }
 
-func addEntriesToDatabase(entries []model.Entry) error {
-	db := DBMustConnect()
-
+// addEntriesToDatabase saves the entries, and returns how many were added,
+// and how many were ignored as duplicates
+func addEntriesToDatabase(db *sqlx.DB, entries []model.Entry) (added int, ignored int, err error) {
 	for _, entry := range entries {
 		// ignore empty results
 		if entry.BibNum == 0 {
@@ -39,50 +40,94 @@ func addEntriesToDatabase(entries []model.Entry) error {
 		fmt.Printf("Adding Entry for %s (bib # %d)..", entry.BoatName, entry.BibNum)
 		ok, err := entry.Insert(db)
 		if err != nil {
-			return err
+			return added, ignored, err
 		}
 
 		if !ok {
 			fmt.Println(" duplicate entry, ignored.")
+			ignored++
 		} else {
 			fmt.Println(" done.")
+			added++
 		}
 	}
 
-	return nil
+	linked, created, ambiguous, err := model.LinkAthletes(db)
+	if err != nil {
+		return added, ignored, err
+	}
+	fmt.Printf("Linked %d entries to athletes, created %d new athletes.\n", linked, created)
+	if ambiguous > 0 {
+		fmt.Printf("%d of the new athletes might be duplicates, check them with 'race athletes review'.\n", ambiguous)
+	}
 
+	// Notify listeners that entries have changed
+	return added, ignored, model.NotifyEntries(db)
+}
+
+// parseDOB returns the date of birth in the column as YYYY-MM-DD, or an empty
+// string if the column isn't configured or is blank
+func parseDOB(row []string, col int) (string, error) {
+	if col < 0 || col >= len(row) || strings.TrimSpace(row[col]) == "" {
+		return "", nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "1/2/2006", "01/02/2006", "1/2/06"} {
+		if dob, err := time.Parse(layout, strings.TrimSpace(row[col])); err == nil {
+			return dob.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("unknown date format")
 }
 
-func importRows(rows [][]string) error {
+func importRows(db *sqlx.DB, rows [][]string) (added int, ignored int, err error) {
 	var entries []model.Entry
 
+	if len(rows) == 0 {
+		return 0, 0, fmt.Errorf("the entries file is empty")
+	}
+	if errs := C.checkEntryWidth(len(rows[0])); len(errs) > 0 {
+		return 0, 0, errs[0]
+	}
+	width := len(rows[0])
+
 	// ignore the header row
 	for rowid, row := range rows[1:] {
 		ErrorRow := rowid + 2 // for error reporting, the row # as soon in Excel
 
+		// short rows have blank cells at the end
+		for len(row) < width {
+			row = append(row, "")
+		}
+
 		if row[C.EntryCols.EventID] == "" {
 			continue //ignore empty rows
 		}
 
 		eventID, err := strconv.Atoi(row[C.EntryCols.EventID])
 		if err != nil {
-			return fmt.Errorf("Row %d, invalid event id: '%v'", ErrorRow, row[C.EntryCols.EventID])
+			return 0, 0, fmt.Errorf("Row %d, invalid event id: '%v'", ErrorRow, row[C.EntryCols.EventID])
 		}
 
 		boatID, err := strconv.Atoi(row[C.EntryCols.BoatID])
 		if err != nil {
-			return fmt.Errorf("Row %d, invalid boat id: '%v'", ErrorRow, row[C.EntryCols.BoatID])
+			return 0, 0, fmt.Errorf("Row %d, invalid boat id: '%v'", ErrorRow, row[C.EntryCols.BoatID])
 
 		}
 
 		age, err := strconv.Atoi(row[C.EntryCols.Age])
 		if err != nil {
-			return fmt.Errorf("Row %d, invalid age: %v", ErrorRow, row[C.EntryCols.Age])
+			return 0, 0, fmt.Errorf("Row %d, invalid age: %v", ErrorRow, row[C.EntryCols.Age])
 		}
 
 		seed, err := time.ParseDuration(strings.Replace(row[C.EntryCols.Seed], ":", "m", 1) + "s")
 		if err != nil {
-			return fmt.Errorf("Row %d, invalid seed time: %v", ErrorRow, row[C.EntryCols.Seed])
+			return 0, 0, fmt.Errorf("Row %d, invalid seed time: %v", ErrorRow, row[C.EntryCols.Seed])
+		}
+
+		dob, err := parseDOB(row, C.EntryCols.DOB)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Row %d, invalid date of birth: %v", ErrorRow, row[C.EntryCols.DOB])
 		}
 
 		entry := model.Entry{
@@ -95,11 +140,12 @@ func importRows(rows [][]string) error {
 			BoatName:   row[C.EntryCols.BoatName],
 			Country:    row[C.EntryCols.Country],
 			BibNum:     boatID,
+			DOB:        dob,
 		}
 
 		entries = append(entries, entry)
 	}
-	return addEntriesToDatabase(entries)
+	return addEntriesToDatabase(db, entries)
 }
 
 func importEntries() {
@@ -112,7 +158,10 @@ func importEntries() {
 
 	rows := workbook.ReadAllCells(C.MaxEntries)
 
-	if err := importRows(rows); err != nil {
+	db := DBMustConnect()
+	added, ignored, err := importRows(db, rows)
+	logImport(db, model.Import{Kind: "entries", Filename: EntriesFilename, Added: added, Ignored: ignored}, err)
+	if err != nil {
 		fmt.Println("Error importing entries:", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,309 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+var exportLive bool
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export events, entries, races and results as JSON and CSV",
+	Long: `The export command writes machine readable copies of the regatta to the
+ExportPath.  Each of events, entries, races and results is written as both
+a .json and a .csv file.
+
+With --live, the files are rewritten every time results or entries change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if exportLive {
+			err = publishLiveOn(Export, "results", "entries")
+		} else {
+			err = Export()
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().BoolVar(&exportLive, "live", false, "Export again as results and entries change.")
+}
+
+// ExportEvent is an event as written by the export command
+type ExportEvent struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Start    string `json:"start"`
+	Distance uint   `json:"distance"`
+	Bank     string `json:"bank"`
+	Official bool   `json:"official"`
+}
+
+// ExportEntry is an entry as written by the export command
+type ExportEntry struct {
+	BibNum     int    `json:"bib_num"`
+	EventID    int    `json:"event_id"`
+	BoatName   string `json:"boat_name"`
+	ClubName   string `json:"club_name"`
+	ClubAbbrev string `json:"club_abbrev"`
+	Age        int    `json:"age"`
+	Country    string `json:"country"`
+	Seed       string `json:"seed"`
+	Ltwt       bool   `json:"ltwt"`
+	Scratched  bool   `json:"scratched"`
+	RaceID     int    `json:"race_id"`
+	Lane       int    `json:"lane"`
+}
+
+// ExportLane is one lane of an ExportRace
+type ExportLane struct {
+	Lane       int    `json:"lane"`
+	BibNum     int    `json:"bib_num"`
+	BoatName   string `json:"boat_name"`
+	ClubAbbrev string `json:"club_abbrev"`
+}
+
+// ExportRace is a scheduled race as written by the export command
+type ExportRace struct {
+	ID        int          `json:"id"`
+	Name      string       `json:"name"`
+	Bank      string       `json:"bank"`
+	Distance  uint         `json:"distance"`
+	NLanes    uint         `json:"nlanes"`
+	StartTime string       `json:"start_time"`
+	Lanes     []ExportLane `json:"lanes"`
+}
+
+// ExportResult is one placed result as written by the export command
+type ExportResult struct {
+	EventID    int    `json:"event_id"`
+	EventName  string `json:"event_name"`
+	Place      int    `json:"place"`
+	BibNum     int    `json:"bib_num"`
+	BoatName   string `json:"boat_name"`
+	ClubAbbrev string `json:"club_abbrev"`
+	Time       string `json:"time"`
+	TimeMS     int64  `json:"time_ms"`
+	AvgPace    string `json:"avg_pace"`
+	Distance   int    `json:"distance"`
+	DQ         bool   `json:"dq"`
+}
+
+// Export writes the events, entries, races and results to the ExportPath
+func Export() error {
+	db := DBMustConnect()
+
+	events, err := LoadResults(db)
+	if err != nil {
+		return err
+	}
+
+	entries, err := model.LoadEntries(db)
+	if err != nil {
+		return err
+	}
+
+	races, err := loadExportRaces(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting to", C.ExportPath)
+
+	if err := exportEvents(toExportEvents(events)); err != nil {
+		return err
+	}
+	if err := exportEntries(toExportEntries(entries)); err != nil {
+		return err
+	}
+	if err := exportRaces(races); err != nil {
+		return err
+	}
+	return exportResults(toExportResults(events))
+}
+
+// exportDuration formats a duration for export, 0 means there is no time
+func exportDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return durString(d)
+}
+
+// toExportEvents converts events to their exported form
+func toExportEvents(events []model.Event) []ExportEvent {
+	out := make([]ExportEvent, len(events))
+	for i, e := range events {
+		out[i] = ExportEvent{ID: e.ID, Name: e.Name, Start: e.Start, Distance: e.Distance, Bank: e.Bank, Official: e.Official}
+	}
+	return out
+}
+
+// toExportEntries converts entries to their exported form
+func toExportEntries(entries []model.Entry) []ExportEntry {
+	out := make([]ExportEntry, len(entries))
+	for i, e := range entries {
+		out[i] = ExportEntry{
+			BibNum:     e.BibNum,
+			EventID:    e.EventID,
+			BoatName:   e.BoatName,
+			ClubName:   e.ClubName,
+			ClubAbbrev: e.ClubAbbrev,
+			Age:        e.Age,
+			Country:    e.Country,
+			Seed:       exportDuration(e.Seed),
+			Ltwt:       e.Ltwt,
+			Scratched:  e.Scratched,
+			RaceID:     e.RaceID,
+			Lane:       e.Lane,
+		}
+	}
+	return out
+}
+
+// loadExportRaces builds the exported races from the same schedule that
+// is published as HTML
+func loadExportRaces(db *sqlx.DB) ([]ExportRace, error) {
+	schedule, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	races := make([]ExportRace, len(schedule))
+	for i, race := range schedule {
+		races[i] = ExportRace{
+			ID:        race.ID,
+			Name:      race.Name,
+			Bank:      race.Bank,
+			Distance:  race.Distance,
+			NLanes:    race.NLanes,
+			StartTime: race.Start,
+			Lanes:     make([]ExportLane, len(race.Entries)),
+		}
+		for j, e := range race.Entries {
+			races[i].Lanes[j] = ExportLane{Lane: e.Lane, BibNum: e.BibNum, BoatName: e.BoatName, ClubAbbrev: e.ClubAbbrev}
+		}
+	}
+
+	return races, nil
+}
+
+// toExportResults returns the placed results of every event
+func toExportResults(events []model.Event) []ExportResult {
+	out := make([]ExportResult, 0)
+	for _, event := range events {
+		for _, e := range event.Entries {
+			// entries without a time did not race
+			if e.Result.Time == 0 {
+				continue
+			}
+			out = append(out, ExportResult{
+				EventID:    event.ID,
+				EventName:  event.Name,
+				Place:      e.Result.Place,
+				BibNum:     e.BibNum,
+				BoatName:   e.BoatName,
+				ClubAbbrev: e.ClubAbbrev,
+				Time:       exportDuration(e.Result.Time),
+				TimeMS:     int64(e.Result.Time / time.Millisecond),
+				AvgPace:    exportDuration(e.Result.AvgPace),
+				Distance:   e.Result.Distance,
+				DQ:         e.Result.DQ,
+			})
+		}
+	}
+	return out
+}
+
+func exportEvents(events []ExportEvent) error {
+	rows := [][]string{{"id", "name", "start", "distance", "bank", "official"}}
+	for _, e := range events {
+		rows = append(rows, []string{
+			strconv.Itoa(e.ID), e.Name, e.Start, strconv.FormatUint(uint64(e.Distance), 10), e.Bank,
+			strconv.FormatBool(e.Official),
+		})
+	}
+
+	return writeExport("events", events, rows)
+}
+
+func exportEntries(entries []ExportEntry) error {
+	rows := [][]string{{"bib_num", "event_id", "boat_name", "club_name", "club_abbrev",
+		"age", "country", "seed", "ltwt", "scratched", "race_id", "lane"}}
+	for _, e := range entries {
+		rows = append(rows, []string{
+			strconv.Itoa(e.BibNum), strconv.Itoa(e.EventID), e.BoatName, e.ClubName, e.ClubAbbrev,
+			strconv.Itoa(e.Age), e.Country, e.Seed, strconv.FormatBool(e.Ltwt),
+			strconv.FormatBool(e.Scratched), strconv.Itoa(e.RaceID), strconv.Itoa(e.Lane),
+		})
+	}
+
+	return writeExport("entries", entries, rows)
+}
+
+func exportRaces(races []ExportRace) error {
+	// the CSV has one row for every lane of every race
+	rows := [][]string{{"race_id", "name", "bank", "distance", "start_time",
+		"lane", "bib_num", "boat_name", "club_abbrev"}}
+
+	for _, race := range races {
+		for _, lane := range race.Lanes {
+			rows = append(rows, []string{
+				strconv.Itoa(race.ID), race.Name, race.Bank, strconv.FormatUint(uint64(race.Distance), 10),
+				race.StartTime, strconv.Itoa(lane.Lane), strconv.Itoa(lane.BibNum), lane.BoatName, lane.ClubAbbrev,
+			})
+		}
+	}
+
+	return writeExport("races", races, rows)
+}
+
+func exportResults(results []ExportResult) error {
+	rows := [][]string{{"event_id", "event_name", "place", "bib_num", "boat_name",
+		"club_abbrev", "time", "time_ms", "avg_pace", "distance", "dq"}}
+	for _, r := range results {
+		rows = append(rows, []string{
+			strconv.Itoa(r.EventID), r.EventName, strconv.Itoa(r.Place), strconv.Itoa(r.BibNum), r.BoatName,
+			r.ClubAbbrev, r.Time, strconv.FormatInt(r.TimeMS, 10), r.AvgPace, strconv.Itoa(r.Distance),
+			strconv.FormatBool(r.DQ),
+		})
+	}
+
+	return writeExport("results", results, rows)
+}
+
+// writeExport writes v as name.json and rows as name.csv in the ExportPath
+func writeExport(name string, v interface{}, rows [][]string) error {
+	err := writeFileAtomic(filepath.Join(C.ExportPath, name+".json"), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(C.ExportPath, name+".csv"), func(w io.Writer) error {
+		return csv.NewWriter(w).WriteAll(rows)
+	})
+}
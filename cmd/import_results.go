@@ -4,111 +4,525 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cjrc/race/model"
 	"github.com/fsnotify/fsnotify"
+	"github.com/jmoiron/sqlx"
 	"github.com/spf13/cobra"
 )
 
-var doneCh chan bool
 var liveResults bool
+var acceptCorrections bool
 
-// tallyCmd represents the tally command
-var resultsCmd = &cobra.Command{
+// importResultsCmd represents the import results command
+var importResultsCmd = &cobra.Command{
 	Use:   "results",
 	Short: "Import the race results for each event",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Imports every results file (*.txt) in the ResultsPath that hasn't been
+imported before.  Files that can't be read as results are moved to the
+QuarantinePath, and the problem is recorded in the import log.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+A file with different results for bibs that already have them, such as the
+file of a rerun race, is a correction.  The differences are shown, and once
+confirmed (or with --yes) the corrected results replace the old ones.  The old
+results are kept, see 'race results history'.  A file whose corrections aren't
+confirmed isn't recorded as imported, so they are shown again next time.
+Results of certified events are never changed, and a disqualification stays
+with the corrected result.
+
+With --live, it then watches the ResultsPath, and imports each new or changed
+file once it has been unchanged for ResultsSettle.  Press Ctrl-C to stop.
+While watching, corrections are never asked about: unless --yes is given, they
+are held until 'race import results' is run to confirm them.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := importResults(); err != nil {
+		db := DBMustConnect()
+
+		ledger, err := importResults(db)
+		if err != nil {
 			fmt.Println("Error importing results:", err)
 			os.Exit(1)
 		}
 		if liveResults {
-			importLiveResults()
+			importLiveResults(db, ledger)
 		}
 	},
 }
 
-func addResultsToDatabase(results []model.Result) error {
-	db := DBMustConnect()
+// raceFileID finds the race number in the name of a results file, which
+// the venue racing software names after the .RAC file, like race007.rac
+var raceFileID = regexp.MustCompile(`(?i)^race0*([0-9]+)\.`)
+
+// fileRace returns the scheduled race whose .RAC file the results file is
+// named after, or 0
+func fileRace(filename string, races []model.Race) int {
+	m := raceFileID.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(m[1])
+	for _, race := range races {
+		if race.ID == id {
+			return race.ID
+		}
+	}
+	return 0
+}
+
+// namedRace returns the only scheduled race with the race name in the
+// results, or 0.  Races can share a name, then the bibs decide.
+func namedRace(results []model.Result, races []model.Race) int {
+	if len(results) == 0 || results[0].RaceName == "" {
+		return 0
+	}
+
+	var named []model.Race
+	for _, race := range races {
+		if strings.EqualFold(strings.TrimSpace(race.VenueName()), results[0].RaceName) {
+			named = append(named, race)
+		}
+	}
+	if len(named) != 1 {
+		return 0
+	}
+	return named[0].ID
+}
+
+// mostBibs returns the race that the most scheduled bibs in the results are
+// scheduled in, the lowest numbered on a tie, with how many of them are, and
+// how many of the bibs are scheduled at all.  The race is 0 if none are.
+func mostBibs(results []model.Result, entries map[int]model.Entry) (raceID int, n int, total int) {
+	votes := make(map[int]int)
+	for _, result := range results {
+		entry, ok := entries[result.BibNum]
+		if result.BibNum == 0 || !ok || entry.RaceID == 0 {
+			continue
+		}
+		votes[entry.RaceID]++
+		total++
+	}
+
+	for id, count := range votes {
+		if count > n || (count == n && id < raceID) {
+			raceID, n = id, count
+		}
+	}
+	return raceID, n, total
+}
+
+// bibVote returns the race that more than half of the scheduled bibs in the
+// results are scheduled in, or 0
+func bibVote(results []model.Result, entries map[int]model.Entry) int {
+	raceID, n, total := mostBibs(results, entries)
+	if 2*n > total {
+		return raceID
+	}
+	return 0
+}
+
+// resultsRace works out which race a results file is from.  It is the race
+// whose .RAC file the results file is named after, or else the race named in
+// the results file, or else the race most of its bibs are scheduled in.  If
+// more than half of the bibs are scheduled in another race, the bibs win, as a
+// file can be renamed or a race rerun under the wrong name.  It returns 0 if
+// no scheduled race matches.
+func resultsRace(db *sqlx.DB, filename string, results []model.Result) (int, error) {
+	races, err := model.LoadRaces(db)
+	if err != nil {
+		return 0, err
+	}
+
+	all, err := model.LoadEntries(db)
+	if err != nil {
+		return 0, err
+	}
+	entries := make(map[int]model.Entry, len(all))
+	for _, e := range all {
+		entries[e.BibNum] = e
+	}
+
+	raceID := fileRace(filename, races)
+	if raceID == 0 {
+		raceID = namedRace(results, races)
+	}
+	if majority := bibVote(results, entries); raceID != 0 && majority != 0 && majority != raceID {
+		fmt.Printf("%s looks like race %d, but most of its bibs are scheduled in race %d\n",
+			filepath.Base(filename), raceID, majority)
+		return majority, nil
+	}
+	if raceID != 0 {
+		return raceID, nil
+	}
+
+	raceID, _, _ = mostBibs(results, entries)
+	return raceID, nil
+}
+
+// addResultsToDatabase saves the results, and returns how many were added,
+// and how many were ignored as duplicates.  Results that differ from a bib's
+// current result in the race are corrections, which replace it once confirmed.
+// The operator is only asked to confirm them if interactive is true.  The race
+// the results file is from is marked as completed, once any of them are stored.
+func addResultsToDatabase(db *sqlx.DB, filename string, results []model.Result, interactive bool) (added int, ignored int, err error) {
+	// results of certified events are locked
+	locked, err := model.CertifiedBibs(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	current, err := model.LoadAllResults(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	raceID, err := resultsRace(db, filename, results)
+	if err != nil {
+		return 0, 0, err
+	}
+	if raceID != 0 {
+		fmt.Printf("Results are from race %d\n", raceID)
+	} else {
+		fmt.Println("Results don't match a scheduled race")
+	}
 
+	var corrections []model.Result
 	for _, result := range results {
 		// ignore empty results
 		if result.BibNum == 0 {
 			continue
 		}
+		result.RaceID = raceID
+
 		fmt.Printf("Adding results for %s (bib # %d)..", result.Name, result.BibNum)
+		if locked[result.BibNum] {
+			fmt.Println(" event is certified, ignored.")
+			ignored++
+			continue
+		}
+		if old, ok := current[result.Key()]; ok && !old.Same(result) {
+			fmt.Println(" corrected results.")
+			corrections = append(corrections, result)
+			continue
+		}
 		ok, err := result.Insert(db)
 		if err != nil {
-			return err
+			return added, ignored, err
 		}
 		if !ok {
 			fmt.Println(" duplicate results, ignored.")
+			ignored++
 		} else {
 			fmt.Println(" done.")
+			added++
+		}
+	}
+
+	// the other results are stored even if the corrections are pending
+	var pending error
+	if len(corrections) > 0 {
+		n, err := correctResults(db, current, corrections, interactive)
+		added += n
+		ignored += len(corrections) - n
+		if err == errCorrectionsPending {
+			pending = err
+		} else if err != nil {
+			return added, ignored, err
+		}
+	}
+
+	// the race is only complete once some of its results are stored
+	if raceID != 0 && added > 0 {
+		if err := model.CompleteRace(db, raceID); err != nil {
+			return added, ignored, err
 		}
 	}
 
 	// Notify listeners that new results have been added
-	return model.NotifyResults(db)
+	if err := model.NotifyResults(db); err != nil {
+		return added, ignored, err
+	}
+	return added, ignored, pending
+}
+
+// errCorrectionsPending is returned for a results file whose corrections
+// weren't confirmed.  The file isn't recorded as imported, so importing it
+// again shows the corrections again.
+var errCorrectionsPending = errors.New("corrections were not confirmed, import again to replace the results")
+
+// correctResults shows how the corrections differ from the current results,
+// and replaces them if the operator confirms, or --yes was given.  If it isn't
+// interactive, the operator isn't asked.  It returns how many were replaced,
+// or errCorrectionsPending if they weren't confirmed.
+func correctResults(db *sqlx.DB, current model.CurrentResults, corrections []model.Result, interactive bool) (int, error) {
+	fmt.Printf("\n%d corrected results:\n", len(corrections))
+	for _, result := range corrections {
+		old := current[result.Key()]
+		fmt.Printf("  bib %-5d %s\n", result.BibNum, result.Name)
+		fmt.Printf("    was: %s\n", describeResult(old))
+		fmt.Printf("    now: %s\n", describeResult(result))
+		if old.DQ {
+			fmt.Println("    stays disqualified")
+		}
+	}
+
+	if !acceptCorrections && !interactive {
+		fmt.Println("Corrections held, run 'race import results' to confirm them.")
+		return 0, errCorrectionsPending
+	}
+	if !acceptCorrections && !verifyPrintf("Replace these %d results?", len(corrections)) {
+		fmt.Println("Corrections not confirmed.")
+		return 0, errCorrectionsPending
+	}
+
+	for i, result := range corrections {
+		// a disqualification is a ruling on the bib, not its time, so it
+		// carries over to the corrected result
+		result.DQ = current[result.Key()].DQ
+		if err := result.Supersede(db); err != nil {
+			return i, err
+		}
+	}
+	fmt.Printf("Replaced %d results.\n", len(corrections))
+	return len(corrections), nil
+}
+
+// describeResult is a one line summary of a result, for comparing versions
+func describeResult(result model.Result) string {
+	race := "-"
+	if result.RaceID != 0 {
+		race = fmt.Sprint(result.RaceID)
+	}
+	return fmt.Sprintf("race %s, lane %d, place %d, %s, %dm, %q (%s)",
+		race, result.Lane, result.Place, durString(result.Time), result.Distance, result.Name, result.Source)
+}
+
+// errAlreadyImported is returned for a results file that has been imported before
+var errAlreadyImported = errors.New("already imported")
+
+// importResultsFile reads and saves the results in filename, and records the
+// import in the log.  Files in the ledger have already been imported, and are
+// skipped.  A file that can't be read as results is moved to the QuarantinePath.
+// Corrections are only asked about if interactive is true.
+func importResultsFile(db *sqlx.DB, filename string, ledger map[string]bool, interactive bool) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if ledger[checksum] {
+		return errAlreadyImported
+	}
+
+	fmt.Println("Reading results from", filename)
+	imp := model.Import{Kind: "results", Filename: filename, Checksum: checksum}
+
+	var results []model.Result
+	if err := model.ReadResults(&results, bytes.NewReader(content)); err != nil {
+		err = quarantine(filename, err)
+		logImport(db, imp, err)
+		return err
+	}
+
+	for i := range results {
+		results[i].Source = filepath.Base(filename)
+	}
+
+	imp.Added, imp.Ignored, err = addResultsToDatabase(db, filename, results, interactive)
+	logImport(db, imp, err)
+	if err == nil {
+		ledger[checksum] = true
+	}
+	return err
+}
+
+// quarantinedError is the error for a results file that couldn't be read, and
+// was moved to the QuarantinePath
+type quarantinedError struct {
+	err  error
+	dest string
+}
+
+func (e quarantinedError) Error() string {
+	return fmt.Sprintf("%v, the file was moved to %s", e.err, e.dest)
+}
+
+// quarantine moves a results file that can't be read out of the ResultsPath,
+// so it isn't read again
+func quarantine(filename string, readErr error) error {
+	dest := filepath.Join(C.QuarantinePath, time.Now().Format("20060102-150405-")+filepath.Base(filename))
+	if err := os.MkdirAll(C.QuarantinePath, 0755); err != nil {
+		return fmt.Errorf("%v, and cannot quarantine the file: %v", readErr, err)
+	}
+	if err := os.Rename(filename, dest); err != nil {
+		return fmt.Errorf("%v, and cannot quarantine the file: %v", readErr, err)
+	}
+	return quarantinedError{err: readErr, dest: dest}
 }
 
-func importResults() error {
+// logImport records an import in the database.  A failure to log is reported,
+// but doesn't stop the import.
+func logImport(db *sqlx.DB, imp model.Import, importErr error) {
+	if importErr != nil {
+		imp.Error = importErr.Error()
+	}
+
+	if err := imp.Insert(db); err != nil {
+		fmt.Println("Cannot log import:", err)
+	}
+}
+
+// isResultsFile reports if the file is one the venue racing software writes
+func isResultsFile(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".txt")
+}
+
+func importResults(db *sqlx.DB) (map[string]bool, error) {
+	ledger, err := model.ImportedChecksums(db, "results")
+	if err != nil {
+		return nil, err
+	}
+
 	// find all the results files in the configured results path
 	filenames, err := filepath.Glob(filepath.Join(C.ResultsPath, "*.txt"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, filename := range filenames {
-		fmt.Println("Reading results from", filename)
-		results, err := model.ReadResultsFromFile(filename)
-		if err != nil {
-			return err
+		err := importResultsFile(db, filename, ledger, true)
+		if err == errAlreadyImported {
+			continue
+		}
+		if err == errCorrectionsPending {
+			fmt.Println(filename+":", err)
+			continue
+		}
+		// a bad file was quarantined, carry on with the rest
+		if _, ok := err.(quarantinedError); ok {
+			fmt.Println("Error importing results:", err)
+			continue
 		}
-		if err := addResultsToDatabase(results); err != nil {
-			return err
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return ledger, nil
 }
 
-// watchResults will importResults and the results files are generated by
-// the venue racing software
-func watchResults(watcher *fsnotify.Watcher) {
+// settling is a results file that is waiting to be unchanged for ResultsSettle
+type settling struct {
+	filename string
+	timer    *time.Timer
+	info     os.FileInfo
+}
+
+// watchResults imports results files as the venue racing software writes
+// them, until it is interrupted.  A file is only imported once it has stopped
+// changing, so half written files aren't read.
+func watchResults(db *sqlx.DB, watcher *fsnotify.Watcher, ledger map[string]bool) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	pending := make(map[string]*settling)
+	settled := make(chan *settling)
+
+	// settle (re)starts the wait for the file to stop changing
+	settle := func(filename string) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return
+		}
+
+		if p, ok := pending[filename]; ok {
+			p.timer.Stop()
+		}
+		p := &settling{filename: filename, info: info}
+		p.timer = time.AfterFunc(C.ResultsSettle, func() {
+			settled <- p
+		})
+		pending[filename] = p
+	}
+
+	fmt.Println("\nWaiting for live results..")
 	for {
-		fmt.Println("\nWaiting for live results..")
 		select {
 		case event := <-watcher.Events:
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				fmt.Println("Reading results from", event.Name)
-				results, err := model.ReadResultsFromFile(event.Name)
-				if err != nil {
-					fmt.Println("Error reading results:", err)
-					doneCh <- true
-				}
-				if err := addResultsToDatabase(results); err != nil {
-					fmt.Println("Error saving results:", err)
-					doneCh <- true
+			if !isResultsFile(event.Name) {
+				continue
+			}
+
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				settle(event.Name)
+
+			// a renamed file arrives as a Create of its new name
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				if p, ok := pending[event.Name]; ok {
+					p.timer.Stop()
+					delete(pending, event.Name)
 				}
 			}
+
+		case p := <-settled:
+			// ignore timers that were restarted, or cancelled, as they fired
+			filename := p.filename
+			if pending[filename] != p {
+				continue
+			}
+
+			// the file changed without an event, so wait some more
+			info, err := os.Stat(filename)
+			if err == nil && (info.Size() != p.info.Size() || !info.ModTime().Equal(p.info.ModTime())) {
+				settle(filename)
+				continue
+			}
+			delete(pending, filename)
+			if err != nil {
+				continue
+			}
+
+			// the operator isn't asked, it would stop the watching
+			err = importResultsFile(db, filename, ledger, false)
+			if err == errAlreadyImported {
+				fmt.Println("Already imported", filename)
+			} else if err == errCorrectionsPending {
+				fmt.Println(filename+":", err)
+			} else if err != nil {
+				fmt.Println("Error importing results:", err)
+			}
+			fmt.Println("\nWaiting for live results..")
+
 		case err := <-watcher.Errors:
 			fmt.Println("Error watching:", err)
-			doneCh <- true
+
+		case <-interrupt:
+			for _, p := range pending {
+				p.timer.Stop()
+			}
+			fmt.Println("Stopped watching for results.")
+			return
 		}
 	}
 }
 
-func importLiveResults() {
+func importLiveResults(db *sqlx.DB, ledger map[string]bool) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		fmt.Println("Cannot create watcher:", err)
@@ -116,21 +530,19 @@ func importLiveResults() {
 	}
 	defer watcher.Close()
 
-	doneCh = make(chan bool)
-	go watchResults(watcher)
-
 	err = watcher.Add(C.ResultsPath)
 	if err != nil {
 		fmt.Println("Cannot watch results:", err)
 		os.Exit(1)
 	}
 
-	<-doneCh
+	watchResults(db, watcher, ledger)
 }
 
 func init() {
-	importCmd.AddCommand(resultsCmd)
+	importCmd.AddCommand(importResultsCmd)
 
-	resultsCmd.Flags().BoolVar(&liveResults, "live", false, "Watch the results path and tally events as new results arrive")
+	importResultsCmd.Flags().BoolVar(&liveResults, "live", false, "Watch the results path and tally events as new results arrive")
+	importResultsCmd.Flags().BoolVarP(&acceptCorrections, "yes", "y", false, "Replace corrected results without asking")
 
 }
@@ -0,0 +1,158 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cjrc/race/model"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	saved := C
+	defer func() { C = saved }()
+	C.QuarantinePath = filepath.Join(dir, "quarantine")
+
+	filename := filepath.Join(dir, "race001.txt")
+	if err := ioutil.WriteFile(filename, []byte("not results"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := quarantine(filename, errors.New("bad results"))
+	q, ok := err.(quarantinedError)
+	if !ok {
+		t.Fatalf("quarantine returned %v, want a quarantinedError", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("%s is still in the results path", filename)
+	}
+	if _, err := os.Stat(q.dest); err != nil {
+		t.Errorf("the file wasn't moved to the quarantine: %v", err)
+	}
+
+	// a file that can't be moved isn't quarantined, so the import stops
+	err = quarantine(filepath.Join(dir, "missing.txt"), errors.New("bad results"))
+	if _, ok := err.(quarantinedError); ok || err == nil {
+		t.Errorf("quarantining a missing file returned %v, want an error that stops the import", err)
+	}
+}
+
+func TestCorrectResultsNotConfirmed(t *testing.T) {
+	// the prompt reads end of file, as it does with no terminal
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	defer r.Close()
+	os.Stdin = r
+
+	current := model.CurrentResults{{RaceID: 3, BibNum: 101}: {RaceID: 3, BibNum: 101, Name: "Smith", Time: 7 * time.Minute}}
+	corrections := []model.Result{{RaceID: 3, BibNum: 101, Name: "Smith", Time: 6 * time.Minute}}
+
+	// the database isn't touched unless the corrections are confirmed
+	n, err := correctResults(nil, current, corrections, true)
+	if err != errCorrectionsPending {
+		t.Errorf("got error %v, want errCorrectionsPending", err)
+	}
+	if n != 0 {
+		t.Errorf("replaced %d results, want 0", n)
+	}
+}
+
+func TestCorrectResultsWhileWatching(t *testing.T) {
+	// the operator would confirm, but mustn't be asked
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("y\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	defer r.Close()
+	os.Stdin = r
+
+	current := model.CurrentResults{{RaceID: 3, BibNum: 101}: {RaceID: 3, BibNum: 101, Name: "Smith", Time: 7 * time.Minute}}
+	corrections := []model.Result{{RaceID: 3, BibNum: 101, Name: "Smith", Time: 6 * time.Minute}}
+
+	n, err := correctResults(nil, current, corrections, false)
+	if err != errCorrectionsPending || n != 0 {
+		t.Errorf("got %d, %v, want the corrections held", n, err)
+	}
+
+	if answer, _ := ioutil.ReadAll(r); string(answer) != "y\n" {
+		t.Errorf("the operator was asked, stdin has %q left", answer)
+	}
+}
+
+func TestFileRace(t *testing.T) {
+	races := []model.Race{{ID: 1}, {ID: 7}, {ID: 12}}
+
+	tests := []struct {
+		filename string
+		want     int
+	}{
+		{"race007.txt", 7},
+		{"Race7.txt", 7},
+		{"results/race012.txt", 12},
+		{"race001.txt", 1},
+		{"race003.txt", 0},
+		{"rerun-race007.txt", 0},
+		{"race007b.txt", 0},
+		{"results.txt", 0},
+	}
+
+	for _, tt := range tests {
+		if got := fileRace(tt.filename, races); got != tt.want {
+			t.Errorf("fileRace(%q) = %d, want %d", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestBibVote(t *testing.T) {
+	entries := map[int]model.Entry{
+		101: {BibNum: 101, RaceID: 1},
+		102: {BibNum: 102, RaceID: 1},
+		103: {BibNum: 103, RaceID: 1},
+		201: {BibNum: 201, RaceID: 2},
+		202: {BibNum: 202, RaceID: 2},
+		301: {BibNum: 301},
+	}
+	results := func(bibs ...int) []model.Result {
+		var r []model.Result
+		for _, bib := range bibs {
+			r = append(r, model.Result{BibNum: bib})
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		results []model.Result
+		want    int
+	}{
+		{"all in one race", results(101, 102, 103), 1},
+		{"most in one race", results(101, 102, 201), 1},
+		{"a tie", results(101, 102, 201, 202), 0},
+		{"unscheduled and unknown bibs don't vote", results(201, 301, 999, 0), 2},
+		{"no scheduled bibs", results(301, 999), 0},
+	}
+
+	for _, tt := range tests {
+		if got := bibVote(tt.results, entries); got != tt.want {
+			t.Errorf("%s: got race %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,11 +4,14 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/cjrc/race/model"
 	_ "github.com/lib/pq" // database driver for Postgres
@@ -19,16 +22,16 @@ var resultsTemplate = `
 <html>
     <head>
         <title>
-            2019 Cincinnati Indoor Rowing Championship Results
+            {{ (regatta).Name }} Results
         </title>
         <meta name="viewport" content="width=device-width, initial-scale=1">
-        <link rel="stylesheet" href="https://www.w3schools.com/w3css/4/w3.css">
+        <link rel="stylesheet" href="w3.css">
     </head>
 
     <body>
 
         <div class="w3-container">
-            <h2>2019 Cincinnati Indoor Rowing Championship</h2>
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
         </div>
 
         <div class="w3-row">
@@ -72,6 +75,8 @@ var resultsTemplate = `
 
     <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
 
+    {{ live }}
+
     </body>
 </html>
 `
@@ -79,18 +84,17 @@ var scheduleTemplate = `
 <html>
     <head>
         <title>
-            2019 Cincinnati Indoor Rowing Championship Schedule
+            {{ (regatta).Name }} Schedule
         </title>
         <meta name="viewport" content="width=device-width, initial-scale=1">
-        <link rel="stylesheet" href="https://www.w3schools.com/w3css/4/w3.css">
-        <script src="https://code.jquery.com/jquery-3.3.1.min.js" integrity="sha256-FgpCb/KJQlLNfOu91ta32o/NMZxltwRo8QtmkMRdAu8="
-        crossorigin="anonymous"></script>
+        <link rel="stylesheet" href="w3.css">
+        <script src="jquery.min.js"></script>
     </head>
 
     <body>
 
         <div class="w3-container">
-            <h2>2019 Cincinnati Indoor Rowing Championship</h2>
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
         </div>
 
         <div class="w3-cell-row">
@@ -99,9 +103,9 @@ var scheduleTemplate = `
         {{ range .Races }}
             {{ if .Entries }}
                 <div class="w3-cell w3-container w3-half w3-margin-bottom w3-margin-top">
-                    <div class="w3-container w3-blue w3-round race" id="race{{.ID}}">
+                    <div class="w3-container {{ if .Completed }}w3-green{{ else }}w3-blue{{ end }} w3-round race" id="race{{.ID}}">
                         <div class="w3-row">
-                        <div class="w3-left w3-cell">{{ .Start }}</div>
+                        <div class="w3-left w3-cell">{{ .Start }}{{ if .Completed }} &mdash; Done{{ end }}</div>
                         <div class="w3-right w3-cell">{{ .Name }}</div>
                         </div><div class="w3-row">
                         <div class="w3-cell w3-left">Race {{ .ID }}, Bank '{{ .Bank }}'</div>
@@ -162,23 +166,631 @@ var scheduleTemplate = `
             })
         </script>
 
+        {{ live }}
+
+    </body>
+</html>
+`
+
+var startlistsTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }} Start Lists
+        </title>
+        <style>
+            body { font-family: Arial, Helvetica, sans-serif; }
+            table { width: 100%; border-collapse: collapse; }
+            th, td { border: 1px solid #000; padding: 6px; text-align: left; }
+            .sheet { page-break-after: always; }
+            .sheet:last-child { page-break-after: auto; }
+            @media print { .noprint { display: none; } }
+        </style>
+    </head>
+
+    <body>
+        <p class="noprint">{{ len .Races }} start lists.  Last updated on {{ now }}.</p>
+
+        {{ range .Races }}
+        <div class="sheet">
+            <h2>{{ (regatta).Name }}</h2>
+            <h1>Race {{ .ID }} &mdash; Bank '{{ .Bank }}' &mdash; {{ .Start }}</h1>
+            <h3>{{ .Name }}, {{ .Distance }} meters</h3>
+            <table>
+                <tr><th>Lane</th><th>Bib</th><th>Name</th><th>Club</th></tr>
+                {{ range .Entries }}
+                <tr>
+                    <td>{{ .Lane }}</td>
+                    <td>{{ .BibNum }}</td>
+                    <td>{{ .BoatName }} {{ ltwt . }}</td>
+                    <td>{{ .ClubName }}</td>
+                </tr>
+                {{ end }}
+            </table>
+        </div>
+        {{ end }}
+    </body>
+</html>
+`
+var banksheetsTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }} Bank Sheets
+        </title>
+        <style>
+            body { font-family: Arial, Helvetica, sans-serif; }
+            table { width: 100%; border-collapse: collapse; margin-bottom: 12px; }
+            th, td { border: 1px solid #000; padding: 4px; text-align: left; }
+            tr { page-break-inside: avoid; }
+            .sheet { page-break-after: always; }
+            .sheet:last-child { page-break-after: auto; }
+            @media print { .noprint { display: none; } }
+        </style>
+    </head>
+
+    <body>
+        <p class="noprint">Last updated on {{ now }}.</p>
+
+        {{ range .Banks }}
+        <div class="sheet">
+            <h2>{{ (regatta).Name }}</h2>
+            <h1>Bank '{{ .Bank }}' Master Sheet</h1>
+            {{ range .Races }}
+            <table>
+                <tr><th colspan="4">Race {{ .ID }} &mdash; {{ .Start }} &mdash; {{ .Name }}, {{ .Distance }} meters</th></tr>
+                <tr><th>Lane</th><th>Bib</th><th>Name</th><th>Club</th></tr>
+                {{ range .Entries }}
+                <tr>
+                    <td>{{ .Lane }}</td>
+                    <td>{{ .BibNum }}</td>
+                    <td>{{ .BoatName }} {{ ltwt . }}</td>
+                    <td>{{ .ClubAbbrev }}</td>
+                </tr>
+                {{ end }}
+            </table>
+            {{ end }}
+        </div>
+        {{ end }}
+    </body>
+</html>
+`
+
+var bibsTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }} Bibs
+        </title>
+        <style>
+            body { font-family: Arial, Helvetica, sans-serif; margin: 0; }
+            .bib { width: 48%; height: 30%; float: left; margin: 1%; border: 1px dashed #999;
+                   text-align: center; box-sizing: border-box; page-break-inside: avoid; }
+            .num { font-size: 96pt; font-weight: bold; }
+            .name { font-size: 18pt; }
+            .club { font-size: 14pt; }
+            @media print { .noprint { display: none; } }
+        </style>
+    </head>
+
+    <body>
+        <p class="noprint">{{ len .Entries }} bibs.  Last updated on {{ now }}.</p>
+
+        {{ range .Entries }}
+        <div class="bib">
+            <div class="num">{{ .BibNum }}</div>
+            <div class="name">{{ .BoatName }}</div>
+            <div class="club">{{ .ClubName }}</div>
+        </div>
+        {{ end }}
+    </body>
+</html>
+`
+var lanecardsTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }} Lane Cards
+        </title>
+        <style>
+            body { font-family: Arial, Helvetica, sans-serif; }
+            table { width: 100%; border-collapse: collapse; }
+            th, td { border: 1px solid #000; padding: 6px; text-align: left; }
+            .card { page-break-after: always; }
+            .card:last-child { page-break-after: auto; }
+            @media print { .noprint { display: none; } }
+        </style>
+    </head>
+
+    <body>
+        <p class="noprint">{{ len .Cards }} lane cards.  Last updated on {{ now }}.</p>
+
+        {{ range .Cards }}
+        <div class="card">
+            <h1>Bank '{{ .Bank }}', Lane {{ .Lane }}</h1>
+            <table>
+                <tr><th>Start</th><th>Race</th><th>Bib</th><th>Name</th><th>Club</th></tr>
+                {{ range .Races }}
+                <tr>
+                    <td>{{ .Start }}</td>
+                    <td>{{ .RaceID }}</td>
+                    <td>{{ .Entry.BibNum }}</td>
+                    <td>{{ .Entry.BoatName }} {{ ltwt .Entry }}</td>
+                    <td>{{ .Entry.ClubAbbrev }}</td>
+                </tr>
+                {{ end }}
+            </table>
+        </div>
+        {{ end }}
+    </body>
+</html>
+`
+
+var announcerTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }} Announcer
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <!-- the race on the ergs changes with the clock, not just with results -->
+        <meta http-equiv="refresh" content="60">
+        <link rel="stylesheet" href="w3.css">
+        <style>
+            body { font-size: 18px; }
+            .record { color: #d32f2f; font-weight: bold; }
+        </style>
+    </head>
+
+    <body>
+
+        <div class="w3-row">
+
+        <div class="w3-container w3-col s12 l6 w3-margin-top">
+            <h3>Now Racing</h3>
+            {{ with .Now }}
+                <div class="w3-container w3-green w3-round">
+                    <div class="w3-left">Race {{ .ID }}, Bank '{{ .Bank }}', {{ .Start }}</div>
+                    <div class="w3-right">{{ .Name }}, {{ .Distance }} meters</div>
+                </div>
+                <table class="w3-table w3-striped">
+                    <tr><th>Lane</th><th>Name</th><th>Club</th><th>Event</th></tr>
+                    {{ range .Lanes }}
+                    <tr><td>{{ .Lane }}</td><td>{{ .BoatName }}{{ if .Ltwt }} (Ltwt){{ end }}</td><td>{{ .ClubName }}</td><td>{{ .EventName }}</td></tr>
+                    {{ end }}
+                </table>
+            {{ else }}
+                <p>Nothing is racing right now.</p>
+            {{ end }}
+
+            <h3>On Deck</h3>
+            {{ range .OnDeck }}
+                <div class="w3-container w3-blue w3-round w3-margin-top">
+                    <div class="w3-left">Race {{ .ID }}, Bank '{{ .Bank }}', {{ .Start }}</div>
+                    <div class="w3-right">{{ .Name }}, {{ .Distance }} meters</div>
+                </div>
+                <table class="w3-table w3-striped">
+                    {{ range .Lanes }}
+                    <tr><td>{{ .Lane }}</td><td>{{ .BoatName }}</td><td>{{ .ClubAbbrev }}</td></tr>
+                    {{ end }}
+                </table>
+            {{ else }}
+                <p>No more races are scheduled.</p>
+            {{ end }}
+        </div>
+
+        <div class="w3-container w3-col s12 l6 w3-margin-top">
+            <h3>Just Finished</h3>
+            {{ with .Finished }}
+                <div class="w3-container w3-amber w3-round">
+                    <div class="w3-left">Race {{ .ID }}, Bank '{{ .Bank }}', {{ .Start }}</div>
+                    <div class="w3-right">{{ .Name }}, {{ .Distance }} meters</div>
+                </div>
+                <table class="w3-table w3-striped">
+                    <tr><th>Place</th><th>Name</th><th>Club</th><th>Time</th><th>Margin</th></tr>
+                    {{ range .Lanes }}
+                    <tr>
+                        <td>{{ if .Place }}{{ .Place }}{{ else }}-{{ end }}</td>
+                        <td>{{ .BoatName }}<br><small>{{ .EventName }}</small></td>
+                        <td>{{ .ClubName }}</td>
+                        <td>{{ .Time }}{{ if .Record }} <span class="record">RECORD</span>{{ end }}</td>
+                        <td>{{ if gt .Place 1 }}{{ .Margin }}{{ end }}</td>
+                    </tr>
+                    {{ end }}
+                </table>
+            {{ else }}
+                <p>No races have finished yet.</p>
+            {{ end }}
+        </div>
+
+        </div>
+
+        <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
+
+        {{ live }}
+
+    </body>
+</html>
+`
+
+var displayTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }}
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="w3.css">
+        <style>
+            html, body { height: 100%; margin: 0; overflow: hidden; background: #000; color: #fff; }
+            .slide { display: none; height: 100%; padding: 2vh 3vw; box-sizing: border-box; }
+            .slide.active { display: block; }
+            .title { font-size: 5vh; font-weight: bold; }
+            .subtitle { font-size: 3vh; color: #90caf9; }
+            table { width: 100%; font-size: 4vh; border-collapse: collapse; margin-top: 2vh; }
+            td { padding: 0.5vh 1vw; border-bottom: 1px solid #333; }
+            .place { width: 8vw; text-align: center; font-weight: bold; }
+            .time { text-align: right; }
+            .footer { position: fixed; bottom: 1vh; width: 100%; text-align: center; font-size: 2vh; color: #777; }
+        </style>
+    </head>
+
+    <body>
+
+        {{ range .Events }}
+        <div class="slide">
+            <div class="title">Event {{ .ID }} &mdash; {{ .Name }}</div>
+            <div class="subtitle">{{ .Distance }} meters &mdash; {{ official . }}</div>
+            <table>
+                {{ range $i, $entry := .Entries }}{{ if lt $i 8 }}
+                <tr>
+                    <td class="place">{{ place $entry }}</td>
+                    <td>{{ $entry.BoatName }} {{ ltwt $entry }}</td>
+                    <td>{{ $entry.ClubAbbrev }}</td>
+                    <td class="time">{{ time $entry }}</td>
+                </tr>
+                {{ end }}{{ end }}
+            </table>
+        </div>
+        {{ end }}
+
+        {{ if .Standings }}
+        <div class="slide">
+            <div class="title">Team Standings</div>
+            <table>
+                {{ range $i, $team := .Standings }}{{ if lt $i 10 }}
+                <tr>
+                    <td class="place">{{ inc $i }}</td>
+                    <td>{{ $team.ClubName }}</td>
+                    <td class="time">{{ $team.Points }}</td>
+                </tr>
+                {{ end }}{{ end }}
+            </table>
+        </div>
+        {{ end }}
+
+        <div class="footer">Last updated on {{ now }}.</div>
+
+        <script>
+            (function () {
+                var slides = document.querySelectorAll(".slide")
+                if (!slides.length) {
+                    return
+                }
+
+                // ?dwell=15 shows each slide for 15 seconds
+                var dwell = {{ .Dwell }}
+                var m = /[?&]dwell=(\d+)/.exec(window.location.search)
+                if (m) {
+                    dwell = parseInt(m[1], 10) * 1000
+                }
+
+                // carry on from the same slide when the page reloads with new results
+                var current = parseInt(sessionStorage.getItem("slide") || "0", 10) % slides.length
+
+                function show() {
+                    slides.forEach(function (s, i) { s.classList.toggle("active", i === current) })
+                    sessionStorage.setItem("slide", current)
+                }
+
+                show()
+                setInterval(function () {
+                    current = (current + 1) % slides.length
+                    show()
+                }, dwell)
+            })()
+        </script>
+
+        {{ live }}
+
+    </body>
+</html>
+`
+
+var eventTemplate = `
+<html>
+    <head>
+        <title>
+            Event {{ .Event.ID }} - {{ .Event.Name }}
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="w3.css">
+    </head>
+
+    <body>
+
+        <div class="w3-container">
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
+            <a href="index.html">All events</a>
+        </div>
+
+        {{ with .Event }}
+        <div class="w3-container w3-margin-bottom w3-margin-top">
+            <div class="w3-container w3-blue w3-round" id="event{{.ID}}">
+                <div class="w3-row">
+                <div class="w3-left w3-cell">Event {{ .ID }}</div>
+                <div class="w3-right w3-cell">{{ .Name }}</div>
+                </div><div class="w3-row">
+                <div class="w3-cell w3-left">{{ .Start }}</div>
+                <div class="w3-right w3-cell">{{ official . }} </div>
+                </div>
+            </div>
+            <div class="w3-row">
+                    <div class="w3-col  w3-center s2"><b>Place</b></div>
+                    <div class="w3-col w3-center s2"><b>Team</b></div>
+                    <div class="w3-col  s6"><b>Name</b></div>
+                    <div class="w3-col  s2 w3-center"><b>Time</b></div>
+            </div>
+            {{ range .Entries }}
+                <div class="w3-row" id="{{.ID}}">
+                    <div class="w3-col  w3-center s2">{{ place . }}</div>
+                    <div class="w3-col w3-center s2"> {{ .ClubAbbrev }} </div>
+                    <div class="w3-col  s6"> {{ .BoatName }} {{ltwt .}}</div>
+                    <div class="w3-col  s2 w3-center">{{ time . }}</div>
+                </div>
+            {{ end }}
+        </div>
+        {{ end }}
+
+    <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
+
+    {{ live }}
+
+    </body>
+</html>
+`
+
+var indexTemplate = `
+<html>
+    <head>
+        <title>
+            {{ (regatta).Name }}
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="w3.css">
+    </head>
+
+    <body>
+
+        <div class="w3-container">
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
+            {{ with regatta }}
+            <p>{{ .Date }}{{ if .Venue }}, {{ .Venue }}{{ end }}</p>
+            {{ if .Contact }}<p>Contact: {{ .Contact }}</p>{{ end }}
+            {{ end }}
+            <p><a href="schedule.html">Schedule</a> | <a href="results.html">All results</a></p>
+        </div>
+
+        <div class="w3-container w3-margin-bottom">
+            <input class="w3-input w3-border" id="search" type="text" placeholder="Find an athlete by name or bib number">
+            <ul class="w3-ul" id="athletes">
+            {{ range .Athletes }}
+                <li class="athlete" data-search="{{ .Search }}" style="display:none">
+                    <a href="{{ .Page }}">{{ .Name }}</a> ({{ .ClubAbbrev }})
+                </li>
+            {{ end }}
+            </ul>
+        </div>
+
+        <div class="w3-container">
+        <table class="w3-table w3-striped w3-bordered">
+            <tr>
+                <th>Event</th>
+                <th>Name</th>
+                <th>Start</th>
+                <th>Entries</th>
+                <th>Status</th>
+            </tr>
+            {{ range .Events }}
+            <tr>
+                <td>{{ .ID }}</td>
+                <td><a href="event-{{ .ID }}.html">{{ .Name }}</a></td>
+                <td>{{ .Start }}</td>
+                <td>{{ len .Entries }}</td>
+                <td>{{ official . }}</td>
+            </tr>
+            {{ end }}
+        </table>
+        </div>
+
+        <div class="w3-container w3-margin-top">
+            <h3>Clubs</h3>
+            {{ range .Clubs }}
+                <a class="w3-button w3-border w3-margin-bottom" href="{{ .Page }}">{{ .Abbrev }}</a>
+            {{ end }}
+        </div>
+
+    <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
+
+        <script>
+            var search = document.getElementById("search")
+            var athletes = document.querySelectorAll(".athlete")
+
+            search.addEventListener("input", function () {
+                var terms = search.value.toLowerCase().trim().split(/\s+/)
+
+                athletes.forEach(function (athlete) {
+                    var text = athlete.getAttribute("data-search")
+                    var found = terms[0] !== "" && terms.every(function (term) {
+                        return text.indexOf(term) >= 0
+                    })
+                    athlete.style.display = found ? "" : "none"
+                })
+            })
+        </script>
+
+    {{ live }}
+
+    </body>
+</html>
+`
+
+var clubTemplate = `
+<html>
+    <head>
+        <title>
+            {{ .Club.Name }} ({{ .Club.Abbrev }})
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="w3.css">
+    </head>
+
+    <body>
+
+        <div class="w3-container">
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
+            <a href="index.html">All events</a>
+        </div>
+
+        {{ with .Club }}
+        <div class="w3-container">
+            <h3>{{ .Name }} ({{ .Abbrev }})</h3>
+        <table class="w3-table w3-striped w3-bordered">
+            <tr>
+                <th>Event</th>
+                <th>Bib</th>
+                <th>Name</th>
+                <th>Race</th>
+                <th>Lane</th>
+                <th>Start</th>
+                <th>Place</th>
+                <th>Time</th>
+            </tr>
+            {{ range .Entries }}
+            <tr>
+                <td><a href="event-{{ .EventID }}.html">{{ .EventName }}</a></td>
+                <td>{{ .BibNum }}</td>
+                <td><a href="{{ .Athlete }}">{{ .BoatName }}</a>{{ if .Ltwt }} (Ltwt){{ end }}</td>
+                {{ if .Scratched }}
+                <td colspan="5"><i>Scratched</i></td>
+                {{ else if .RaceID }}
+                <td><a href="schedule.html#race{{ .RaceID }}">{{ .RaceName }}</a></td>
+                <td>{{ .Lane }}</td>
+                <td>{{ .Start }}</td>
+                <td>{{ .Place }}</td>
+                <td>{{ .Time }}</td>
+                {{ else }}
+                <td colspan="3"><i>Not scheduled yet</i></td>
+                <td>{{ .Place }}</td>
+                <td>{{ .Time }}</td>
+                {{ end }}
+            </tr>
+            {{ end }}
+        </table>
+        </div>
+        {{ end }}
+
+    <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
+
+    {{ live }}
+
+    </body>
+</html>
+`
+
+var athleteTemplate = `
+<html>
+    <head>
+        <title>
+            {{ .Athlete.Name }} - {{ .Athlete.ClubAbbrev }}
+        </title>
+        <meta name="viewport" content="width=device-width, initial-scale=1">
+        <link rel="stylesheet" href="w3.css">
+    </head>
+
+    <body>
+
+        <div class="w3-container">
+            <h2>{{ with (regatta).Logo }}<img src="{{ . }}" style="height:1.5em"> {{ end }}{{ (regatta).Name }}</h2>
+            <a href="index.html">All events</a>
+        </div>
+
+        {{ with .Athlete }}
+        <div class="w3-container">
+            <h3>{{ .Name }}, <a href="club-{{ pageSlug .ClubAbbrev }}.html">{{ .ClubName }}</a></h3>
+        <table class="w3-table w3-striped w3-bordered">
+            <tr>
+                <th>Event</th>
+                <th>Bib</th>
+                <th>Name</th>
+                <th>Race</th>
+                <th>Lane</th>
+                <th>Start</th>
+                <th>Place</th>
+                <th>Time</th>
+            </tr>
+            {{ range .Entries }}
+            <tr>
+                <td><a href="event-{{ .EventID }}.html">{{ .EventName }}</a></td>
+                <td>{{ .BibNum }}</td>
+                <td><a href="{{ .Athlete }}">{{ .BoatName }}</a>{{ if .Ltwt }} (Ltwt){{ end }}</td>
+                {{ if .Scratched }}
+                <td colspan="5"><i>Scratched</i></td>
+                {{ else if .RaceID }}
+                <td><a href="schedule.html#race{{ .RaceID }}">{{ .RaceName }}</a></td>
+                <td>{{ .Lane }}</td>
+                <td>{{ .Start }}</td>
+                <td>{{ .Place }}</td>
+                <td>{{ .Time }}</td>
+                {{ else }}
+                <td colspan="3"><i>Not scheduled yet</i></td>
+                <td>{{ .Place }}</td>
+                <td>{{ .Time }}</td>
+                {{ end }}
+            </tr>
+            {{ end }}
+        </table>
+        </div>
+        {{ end }}
+
+    <div class="w3-col s12 w3-cell w3-center">Last updated on {{ now }}.</div>
+
+    {{ live }}
+
     </body>
 </html>
 `
 
 var noCreateTables bool
+var newRegatta Regatta
 
 // initCmd represents the init command
 var newCmd = &cobra.Command{
-	Use:   "new",
-	Short: "Creates a new regatta (config file, database tables, etc..)",
+	Use:         "new",
+	Annotations: uncheckedConfig,
+	Short:       "Creates a new regatta (config file, database tables, etc..)",
 	Long: `The 'new' command will create a new regatta in the current directory.
 
 Note:
  - The current directory must be empty.  
  - A database must be specified by either the RACE_DB environment variable or --db flag.  
  
-The new command will create all the necessary tables and indices in the race database.`,
+The new command will create all the necessary tables and indices in the race database.
+With --no-create-tables, the new regatta is added to a database that already has
+them, so past regattas are kept alongside it.
+
+The regatta's name, date, venue, logo, time zone and contact are shown on the
+published pages.  Any that aren't given as flags are asked for.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// A New regatta will be created in the current working directory
 		pwd, err := os.Getwd()
@@ -200,7 +812,13 @@ The new command will create all the necessary tables and indices in the race dat
 			os.Exit(1)
 		}
 
-		// if user specifies not to create tables, we don't mess with the database at all
+		if err := askRegatta(cmd, &newRegatta); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		C.Regatta = newRegatta
+
+		// if user specifies not to create tables, the regatta is added to the existing database
 		if !noCreateTables {
 			// we must know what database to use
 			if C.DB == "" {
@@ -214,6 +832,14 @@ The new command will create all the necessary tables and indices in the race dat
 				os.Exit(1)
 			}
 		}
+
+		if C.DB != "" {
+			fmt.Println("adding the regatta to the database")
+			if err := createRegatta(&C.Regatta); err != nil {
+				fmt.Println("Cannot add the regatta:", err)
+				os.Exit(1)
+			}
+		}
 		// Create the new regatta
 		fmt.Println("creating default config")
 		C.WriteToFile("race.yaml")
@@ -227,18 +853,78 @@ The new command will create all the necessary tables and indices in the race dat
 		if err := createTemplates(); err != nil {
 			fmt.Println("Can't create html templates:", err)
 		}
+
+		fmt.Println("copying stylesheets and scripts")
+		if err := copyAssets(); err != nil {
+			fmt.Println("Can't copy stylesheets and scripts:", err)
+		}
 	},
 }
 
-func createTemplates() error {
-	resultsFilename := filepath.Join(C.TemplatePath, "results.html")
-	scheduleFilename := filepath.Join(C.TemplatePath, "schedule.html")
+// assignmentTemplate is the email 'race notify assignments' sends each athlete,
+// it starts with the Subject header
+var assignmentTemplate = `Subject: {{ (regatta).Name }}: your race assignment
 
-	if err := ioutil.WriteFile(resultsFilename, []byte(resultsTemplate), 0644); err != nil {
-		return err
+Hello {{ .Entry.BoatName }},
+
+You are racing in {{ (regatta).Name }}{{ with (regatta).Date }} on {{ . }}{{ end }}{{ with (regatta).Venue }} at {{ . }}{{ end }}.
+
+  Event:  {{ .Event.ID }}, {{ .Event.Name }}
+  Race:   {{ .Race.ID }}, {{ .Race.Name }}, {{ .Race.Distance }} meters
+  Start:  {{ .Race.Start }}
+  Bank:   {{ .Race.Bank }}
+  Lane:   {{ .Entry.Lane }}
+  Bib:    {{ .Entry.BibNum }}
+
+Please be at your erg ten minutes before the start.
+{{ with (regatta).Contact }}
+Questions? Contact {{ . }}
+{{ end }}`
+
+// resultTemplate is the email 'race notify results' sends each athlete once
+// their event is certified
+var resultTemplate = `Subject: {{ (regatta).Name }}: your official result
+
+Hello {{ .Entry.BoatName }},
+
+The results of event {{ .Event.ID }}, {{ .Event.Name }}, are official.
+
+  Place:  {{ place .Entry }} of {{ len .Event.Entries }}
+  Time:   {{ time .Entry }}
+  Bib:    {{ .Entry.BibNum }}
+
+Thank you for racing at {{ (regatta).Name }}.
+{{ with (regatta).Contact }}
+Questions? Contact {{ . }}
+{{ end }}`
+
+// templates are the default templates written to the TemplatePath by 'race new'
+var templates = map[string]string{
+	"results.html":    resultsTemplate,
+	"schedule.html":   scheduleTemplate,
+	"startlists.html": startlistsTemplate,
+	"banksheets.html": banksheetsTemplate,
+	"bibs.html":       bibsTemplate,
+	"lanecards.html":  lanecardsTemplate,
+	"announcer.html":  announcerTemplate,
+	"display.html":    displayTemplate,
+	"event.html":      eventTemplate,
+	"index.html":      indexTemplate,
+	"club.html":       clubTemplate,
+	"athlete.html":    athleteTemplate,
+	"assignment.eml":  assignmentTemplate,
+	"result.eml":      resultTemplate,
+}
+
+func createTemplates() error {
+	for name, content := range templates {
+		filename := filepath.Join(C.TemplatePath, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			return err
+		}
 	}
 
-	return ioutil.WriteFile(scheduleFilename, []byte(scheduleTemplate), 0644)
+	return nil
 }
 
 func createFolders() error {
@@ -252,6 +938,15 @@ func createFolders() error {
 	if err := os.MkdirAll(C.RacePath, 0755); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(C.ExportPath, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(C.QuarantinePath, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(C.OutboxPath, 0755); err != nil {
+		return err
+	}
 	return os.MkdirAll(C.ResultsPath, 0755)
 }
 
@@ -269,12 +964,30 @@ func isDirEmpty(name string) (bool, error) {
 	return false, err // Either not empty or error, suits both cases
 }
 
+// createRegatta adds the regatta to the Regattas table, and sets its ID
+func createRegatta(regatta *Regatta) error {
+	r := model.Regatta{Name: regatta.Name, Date: regatta.Date, Venue: regatta.Venue}
+	if err := r.Insert(DBMustConnect()); err != nil {
+		return err
+	}
+
+	regatta.ID = r.ID
+	return nil
+}
+
 func createDatabase() error {
 	var schema []string
 
+	schema = append(schema, model.RegattaSchema...)
+	schema = append(schema, model.AthleteSchema...)
 	schema = append(schema, model.EntrySchema...)
 	schema = append(schema, model.ResultSchema...)
 	schema = append(schema, model.RaceSchema...)
+	schema = append(schema, model.ImportSchema...)
+	schema = append(schema, model.CertificationSchema...)
+	schema = append(schema, model.ProtestSchema...)
+	schema = append(schema, model.NotificationSchema...)
+	schema = append(schema, model.ChangeSchema...) // after the tables it watches
 
 	db := DBMustConnect()
 
@@ -300,4 +1013,48 @@ func init() {
 	// is called directly, e.g.:
 	// initCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	newCmd.Flags().BoolVar(&noCreateTables, "no-create-tables", false, "Don't create Database tables for a new race")
+	newCmd.Flags().StringVar(&newRegatta.Name, "name", "", "Name of the regatta, shown on every page")
+	newCmd.Flags().StringVar(&newRegatta.Date, "date", "", "Date of the regatta")
+	newCmd.Flags().StringVar(&newRegatta.Venue, "venue", "", "Where the regatta is held")
+	newCmd.Flags().StringVar(&newRegatta.Logo, "logo", "", "URL of the regatta's logo, or a filename in the HTMLPath")
+	newCmd.Flags().StringVar(&newRegatta.TimeZone, "timezone", "", "Time zone of the regatta, like America/New_York")
+	newCmd.Flags().StringVar(&newRegatta.Contact, "contact", "", "Who to contact about the regatta")
+}
+
+// askRegatta prompts for the regatta details that weren't given as flags
+func askRegatta(cmd *cobra.Command, regatta *Regatta) error {
+	questions := []struct {
+		flag   string
+		prompt string
+		value  *string
+	}{
+		{"name", "Regatta name", &regatta.Name},
+		{"date", "Date", &regatta.Date},
+		{"venue", "Venue", &regatta.Venue},
+		{"logo", "Logo URL or filename", &regatta.Logo},
+		{"timezone", "Time zone (like America/New_York)", &regatta.TimeZone},
+		{"contact", "Contact", &regatta.Contact},
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for _, q := range questions {
+		if cmd.Flags().Changed(q.flag) {
+			continue
+		}
+
+		fmt.Printf("%s: ", q.prompt)
+		answer, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		*q.value = strings.TrimSpace(answer)
+	}
+
+	if regatta.TimeZone != "" {
+		if _, err := time.LoadLocation(regatta.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone %q", regatta.TimeZone)
+		}
+	}
+
+	return nil
 }
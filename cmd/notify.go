@@ -0,0 +1,318 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+var notifyDryRun bool
+var notifyEventID int
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Email athletes their race assignments and results",
+	Long: `Emails every athlete with an email address in the entries.  The emails are
+made from the assignment.eml and result.eml templates in the TemplatePath, and
+sent through the SMTP server in the config.
+
+Each email is only sent once, unless it changes, so an athlete who is moved to
+another lane gets a new assignment.  With --dry-run the emails are written to
+the OutboxPath instead of being sent.`,
+}
+
+// notifyAssignmentsCmd represents the notify assignments command
+var notifyAssignmentsCmd = &cobra.Command{
+	Use:   "assignments",
+	Short: "Email athletes their event, race, bank, lane and start time",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := DBMustConnect()
+
+		emails, err := assignmentEmails(db)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := notify(db, "assignments", emails); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// notifyResultsCmd represents the notify results command
+var notifyResultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Email athletes their result and place once their event is certified",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := DBMustConnect()
+
+		emails, err := resultEmails(db, notifyEventID)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := notify(db, "results", emails); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyAssignmentsCmd)
+	notifyCmd.AddCommand(notifyResultsCmd)
+
+	notifyCmd.PersistentFlags().BoolVar(&notifyDryRun, "dry-run", false, "Write the emails to the OutboxPath instead of sending them")
+	notifyResultsCmd.Flags().IntVar(&notifyEventID, "event", 0, "Only email the results of this event (default every certified event)")
+}
+
+// Email is one message to an athlete
+type Email struct {
+	To      string
+	BibNum  int
+	Message []byte // the rendered template, starting with its Subject header
+}
+
+// digest identifies the email, so it isn't sent twice
+func (email Email) digest() string {
+	sum := sha256.Sum256(append([]byte(email.To+"\n"), email.Message...))
+	return hex.EncodeToString(sum[:])
+}
+
+// headers are the headers added to the rendered template
+func (email Email) headers() string {
+	return fmt.Sprintf("From: %s\nTo: %s\nDate: %s\nMIME-Version: 1.0\nContent-Type: text/plain; charset=utf-8\n",
+		C.SMTP.From, email.To, time.Now().Format(time.RFC1123Z))
+}
+
+// renderEmail renders the named email template from the TemplatePath.  The
+// templates are plain text, and have the same functions as the HTML templates.
+func renderEmail(name string, to string, bibNum int, data interface{}) (Email, error) {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return Email{}, fmt.Errorf("invalid email address %q for bib %d", to, bibNum)
+	}
+
+	templatePath := path.Join(C.TemplatePath, name)
+	t, err := texttemplate.New(path.Base(templatePath)).Funcs(texttemplate.FuncMap(templateFuncs())).ParseFiles(templatePath)
+	if err != nil {
+		return Email{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return Email{}, err
+	}
+
+	return Email{To: addr.Address, BibNum: bibNum, Message: encodeSubject(buf.Bytes())}, nil
+}
+
+// encodeSubject Q-encodes the Subject header of a rendered email, as headers
+// can only be ASCII and athletes' names often aren't.  A subject that is
+// already ASCII is left as it is.
+func encodeSubject(message []byte) []byte {
+	header, body := message, []byte(nil)
+	if i := bytes.Index(message, []byte("\n\n")); i >= 0 {
+		header, body = message[:i], message[i:]
+	}
+
+	const name = "Subject:"
+	lines := strings.Split(string(header), "\n")
+	for i, line := range lines {
+		if len(line) < len(name) || !strings.EqualFold(line[:len(name)], name) {
+			continue
+		}
+		eol := ""
+		if strings.HasSuffix(line, "\r") {
+			eol = "\r"
+		}
+		subject := strings.TrimSpace(line[len(name):])
+		lines[i] = "Subject: " + mime.QEncoding.Encode("utf-8", subject) + eol
+	}
+
+	return append([]byte(strings.Join(lines, "\n")), body...)
+}
+
+// assignmentEmails tells every scheduled athlete where and when they race
+func assignmentEmails(db *sqlx.DB) ([]Email, error) {
+	races, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []Email
+	for _, race := range races {
+		for _, entry := range race.Entries {
+			if entry.Email == "" || entry.Scratched {
+				continue
+			}
+
+			event, err := findEvent(entry.EventID)
+			if err != nil {
+				return nil, err
+			}
+
+			data := map[string]interface{}{
+				"Entry": entry,
+				"Event": event,
+				"Race":  race,
+			}
+			email, err := renderEmail("assignment.eml", entry.Email, entry.BibNum, data)
+			if err != nil {
+				return nil, err
+			}
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, nil
+}
+
+// resultEmails tells every athlete in a certified event their result.  If
+// eventID isn't 0, only that event's athletes are emailed.
+func resultEmails(db *sqlx.DB, eventID int) ([]Email, error) {
+	if eventID != 0 {
+		if _, err := findEvent(eventID); err != nil {
+			return nil, err
+		}
+	}
+
+	events, err := LoadResults(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []Email
+	for _, event := range events {
+		if eventID != 0 && event.ID != eventID {
+			continue
+		}
+		if !event.Official {
+			if eventID != 0 {
+				return nil, fmt.Errorf("event %d (%s) isn't certified", event.ID, event.Name)
+			}
+			continue
+		}
+
+		for _, entry := range event.Entries {
+			if entry.Email == "" {
+				continue
+			}
+
+			data := map[string]interface{}{
+				"Entry": entry,
+				"Event": event,
+			}
+			email, err := renderEmail("result.eml", entry.Email, entry.BibNum, data)
+			if err != nil {
+				return nil, err
+			}
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, nil
+}
+
+// notify sends the emails that haven't been sent before, and logs them in
+// the database
+func notify(db *sqlx.DB, kind string, emails []Email) error {
+	sent, err := model.SentDigests(db, kind)
+	if err != nil {
+		return err
+	}
+
+	return sendEmails(kind, emails, sent, func(n model.Notification) error {
+		return n.Insert(db)
+	})
+}
+
+// sendEmails sends the emails whose digests aren't in sent, and logs each one
+// that was sent.  A dry run writes them to the OutboxPath instead, and logs
+// nothing.
+func sendEmails(kind string, emails []Email, sent map[string]bool, logSent func(model.Notification) error) error {
+	if !notifyDryRun && C.SMTP.From == "" {
+		return fmt.Errorf("SMTP.From is not set in the config")
+	}
+	if notifyDryRun {
+		if err := os.MkdirAll(C.OutboxPath, 0755); err != nil {
+			return err
+		}
+	}
+
+	addr := net.JoinHostPort(C.SMTP.Host, strconv.Itoa(C.SMTP.Port))
+	var auth smtp.Auth
+	if C.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", C.SMTP.Username, C.SMTP.Password, C.SMTP.Host)
+	}
+
+	var delivered, unchanged, failed int
+	for _, email := range emails {
+		digest := email.digest()
+		if sent[digest] {
+			unchanged++
+			continue
+		}
+
+		message := append([]byte(email.headers()), email.Message...)
+
+		if notifyDryRun {
+			filename := filepath.Join(C.OutboxPath, fmt.Sprintf("%s-%d.eml", kind, email.BibNum))
+			if err := ioutil.WriteFile(filename, message, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote email for bib %d to %s\n", email.BibNum, filename)
+			delivered++
+			continue
+		}
+
+		if err := smtp.SendMail(addr, auth, C.SMTP.From, []string{email.To}, message); err != nil {
+			fmt.Printf("Cannot email bib %d (%s): %v\n", email.BibNum, email.To, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Emailed bib %d (%s)\n", email.BibNum, email.To)
+		delivered++
+
+		n := model.Notification{Kind: kind, BibNum: email.BibNum, Email: email.To, Digest: digest}
+		if err := logSent(n); err != nil {
+			return err
+		}
+		sent[digest] = true
+	}
+
+	if notifyDryRun {
+		fmt.Printf("%d emails written, %d already sent.\n", delivered, unchanged)
+	} else {
+		fmt.Printf("%d emails sent, %d already sent, %d failed.\n", delivered, unchanged, failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d emails could not be sent", failed)
+	}
+	return nil
+}
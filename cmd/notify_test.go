@@ -0,0 +1,223 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cjrc/race/model"
+)
+
+// smtpStub is an SMTP server that keeps the messages it is sent.  Mail to
+// the reject address is refused.
+type smtpStub struct {
+	listener net.Listener
+	reject   string
+
+	mu       sync.Mutex
+	messages map[string]string // by recipient
+}
+
+func newSMTPStub(t *testing.T, reject string) *smtpStub {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{listener: l, reject: reject, messages: make(map[string]string)}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprint(conn, line+"\r\n") }
+
+	var to string
+	reply("220 stub ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 stub")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			if to == s.reject {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages[to] = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) message(to string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[to]
+	return m, ok
+}
+
+// testEmails are an email to each address, the first has been sent before
+func testEmails(addrs ...string) ([]Email, map[string]bool) {
+	var emails []Email
+	for i, addr := range addrs {
+		emails = append(emails, Email{
+			To:      addr,
+			BibNum:  101 + i,
+			Message: []byte(fmt.Sprintf("Subject: Your race\n\nBib %d, race 3, lane %d\n", 101+i, i+1)),
+		})
+	}
+	return emails, map[string]bool{emails[0].digest(): true}
+}
+
+func TestSendEmails(t *testing.T) {
+	stub := newSMTPStub(t, "nobody@example.com")
+	addr := stub.listener.Addr().(*net.TCPAddr)
+
+	saved, savedDryRun := C, notifyDryRun
+	defer func() { C, notifyDryRun = saved, savedDryRun }()
+	notifyDryRun = false
+	C.SMTP = SMTP{Host: addr.IP.String(), Port: addr.Port, From: "regatta@example.com"}
+
+	emails, sent := testEmails("old@example.com", "smith@example.com", "nobody@example.com")
+
+	var logged []model.Notification
+	err := sendEmails("assignments", emails, sent, func(n model.Notification) error {
+		logged = append(logged, n)
+		return nil
+	})
+	if err == nil {
+		t.Error("sendEmails didn't report the refused email")
+	}
+
+	if _, ok := stub.message("old@example.com"); ok {
+		t.Error("an email that was sent before was sent again")
+	}
+	m, ok := stub.message("smith@example.com")
+	if !ok {
+		t.Fatal("smith@example.com wasn't emailed")
+	}
+	for _, want := range []string{"From: regatta@example.com", "To: smith@example.com", "Subject: Your race", "Bib 102, race 3, lane 2"} {
+		if !strings.Contains(m, want) {
+			t.Errorf("the email has no %q:\n%s", want, m)
+		}
+	}
+
+	// only the email that was delivered is logged
+	if len(logged) != 1 {
+		t.Fatalf("logged %d emails, want 1", len(logged))
+	}
+	if n := logged[0]; n.Kind != "assignments" || n.BibNum != 102 || n.Email != "smith@example.com" || n.Digest != emails[1].digest() {
+		t.Errorf("logged %+v", n)
+	}
+	if !sent[emails[1].digest()] || sent[emails[2].digest()] {
+		t.Error("the sent digests weren't updated")
+	}
+}
+
+func TestSendEmailsDryRun(t *testing.T) {
+	saved, savedDryRun := C, notifyDryRun
+	defer func() { C, notifyDryRun = saved, savedDryRun }()
+	notifyDryRun = true
+	C.OutboxPath = filepath.Join(t.TempDir(), "outbox")
+	C.SMTP = SMTP{From: "regatta@example.com"}
+
+	emails, sent := testEmails("old@example.com", "smith@example.com")
+
+	err := sendEmails("results", emails, sent, func(n model.Notification) error {
+		t.Errorf("a dry run logged %+v", n)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(C.OutboxPath, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(C.OutboxPath, "results-102.eml")
+	if len(files) != 1 || files[0] != want {
+		t.Fatalf("the outbox has %v, want %s", files, want)
+	}
+
+	content, err := ioutil.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"From: regatta@example.com\n", "To: smith@example.com\n", "Subject: Your race\n", "Bib 102, race 3, lane 2\n"} {
+		if !strings.Contains(string(content), s) {
+			t.Errorf("%s has no %q:\n%s", want, s, content)
+		}
+	}
+}
+
+func TestEncodeSubject(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Subject: Your race\n\nBib 101\n", "Subject: Your race\n\nBib 101\n"},
+		{"Subject: Zoë Müller, race 3\n\nBib 101\n", "Subject: =?utf-8?q?Zo=C3=AB_M=C3=BCller,_race_3?=\n\nBib 101\n"},
+		{"subject:Zoë\r\n\r\nBib 101\r\n", "Subject: =?utf-8?q?Zo=C3=AB?=\r\n\r\nBib 101\r\n"},
+		{"Subject: Your race\n\nSubject: Zoë is in the body\n", "Subject: Your race\n\nSubject: Zoë is in the body\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(encodeSubject([]byte(tt.message))); got != tt.want {
+			t.Errorf("encodeSubject(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
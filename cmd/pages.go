@@ -0,0 +1,335 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// hashesFilename keeps the content hash of every page published to the HTMLPath
+const hashesFilename = ".hashes.json"
+
+// writeFileAtomic writes a temporary file next to filename, and renames it into
+// place once render has succeeded.  Readers see either the old or the new file,
+// never a half written one.
+func writeFileAtomic(filename string, render func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	// this fails harmlessly once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	if err := render(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// TempFile creates files that only the owner can read
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// pageHashes maps a published filename to the hash of the content it was
+// rendered from
+type pageHashes map[string]string
+
+func loadPageHashes() pageHashes {
+	hashes := make(pageHashes)
+
+	// a missing or damaged file just means every page is published again
+	if b, err := ioutil.ReadFile(path.Join(C.HTMLPath, hashesFilename)); err == nil {
+		json.Unmarshal(b, &hashes)
+	}
+	return hashes
+}
+
+func (hashes pageHashes) save() error {
+	return writeFileAtomic(path.Join(C.HTMLPath, hashesFilename), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(hashes)
+	})
+}
+
+// contentHash hashes the template that renders a page, along with its data
+func contentHash(name string, data interface{}) (string, error) {
+	h := sha256.New()
+
+	tmpl, err := ioutil.ReadFile(path.Join(C.TemplatePath, name))
+	if err != nil {
+		return "", err
+	}
+	h.Write(tmpl)
+
+	// the regatta details are on every page
+	if err := json.NewEncoder(h).Encode(C.Regatta); err != nil {
+		return "", err
+	}
+
+	if err := json.NewEncoder(h).Encode(data); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// publishChanged renders the template to filename, unless the page is
+// already published and its content hasn't changed.  It reports if the
+// page was published.
+func (hashes pageHashes) publishChanged(name string, filename string, data interface{}) (bool, error) {
+	sum, err := contentHash(name, data)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(path.Join(C.HTMLPath, filename)); err == nil && hashes[filename] == sum {
+		return false, nil
+	}
+
+	if err := publishTemplate(name, filename, data); err != nil {
+		return false, err
+	}
+	hashes[filename] = sum
+	return true, nil
+}
+
+// resultPagePatterns match the event, club and athlete pages.  They come and
+// go with the entries, unlike the other pages.
+var resultPagePatterns = []string{"event-*.html", "club-*.html", "athlete-*.html"}
+
+func isResultPage(filename string) bool {
+	for _, pattern := range resultPagePatterns {
+		if ok, _ := filepath.Match(pattern, filename); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeStale deletes the event, club and athlete pages that aren't current,
+// and forgets their hashes.  They are left behind when an entry is renamed,
+// changes club or event, or is merged with another athlete.  It returns how
+// many pages were removed.
+func (hashes pageHashes) removeStale(current map[string]bool) (int, error) {
+	stale := make(map[string]bool)
+	for filename := range hashes {
+		if isResultPage(filename) && !current[filename] {
+			stale[filename] = true
+		}
+	}
+	for _, pattern := range resultPagePatterns {
+		matches, err := filepath.Glob(filepath.Join(C.HTMLPath, pattern))
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range matches {
+			if filename := filepath.Base(m); !current[filename] {
+				stale[filename] = true
+			}
+		}
+	}
+
+	for filename := range stale {
+		if err := os.Remove(path.Join(C.HTMLPath, filename)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		delete(hashes, filename)
+	}
+	return len(stale), nil
+}
+
+// eventFilename is the page for one event's results
+func eventFilename(eventID int) string {
+	return fmt.Sprintf("event-%d.html", eventID)
+}
+
+func eventData(event model.Event) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["Event"] = event
+	return data
+}
+
+func indexData(events []model.Event, clubs []Club, athletes []Athlete) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["Events"] = events
+	data["Clubs"] = clubs
+	data["Athletes"] = athletes
+	return data
+}
+
+func indexPage(db *sqlx.DB) (map[string]interface{}, error) {
+	events, err := LoadResults(db)
+	if err != nil {
+		return nil, err
+	}
+
+	clubs, athletes, err := LoadAthletes(db, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return indexData(events, clubs, athletes), nil
+}
+
+// lookupPage returns the template and data loader for a page name, including
+// the event, club and athlete pages that aren't in pages
+func lookupPage(name string) (string, pageLoader, bool) {
+	if load, ok := pages[name]; ok {
+		return name, load, true
+	}
+
+	if !strings.HasSuffix(name, ".html") {
+		return "", nil, false
+	}
+
+	switch {
+	case strings.HasPrefix(name, "event-"):
+		eventID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "event-"), ".html"))
+		if err != nil {
+			return "", nil, false
+		}
+
+		return "event.html", func(db *sqlx.DB) (map[string]interface{}, error) {
+			events, err := LoadResults(db)
+			if err != nil {
+				return nil, err
+			}
+			for _, event := range events {
+				if event.ID == eventID {
+					return eventData(event), nil
+				}
+			}
+			return nil, fmt.Errorf("there is no event %d", eventID)
+		}, true
+
+	case strings.HasPrefix(name, "club-"):
+		return "club.html", func(db *sqlx.DB) (map[string]interface{}, error) {
+			clubs, _, err := loadAthletePages(db)
+			if err != nil {
+				return nil, err
+			}
+			for _, club := range clubs {
+				if club.Page == name {
+					return clubData(club), nil
+				}
+			}
+			return nil, fmt.Errorf("there is no club page %s", name)
+		}, true
+
+	case strings.HasPrefix(name, "athlete-"):
+		return "athlete.html", func(db *sqlx.DB) (map[string]interface{}, error) {
+			_, athletes, err := loadAthletePages(db)
+			if err != nil {
+				return nil, err
+			}
+			for _, athlete := range athletes {
+				if athlete.Page == name {
+					return athleteData(athlete), nil
+				}
+			}
+			return nil, fmt.Errorf("there is no athlete page %s", name)
+		}, true
+	}
+
+	return "", nil, false
+}
+
+// loadAthletePages loads the clubs and athletes along with their results
+func loadAthletePages(db *sqlx.DB) ([]Club, []Athlete, error) {
+	events, err := LoadResults(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return LoadAthletes(db, events)
+}
+
+// publishResultPages publishes a page for every event, club and athlete whose
+// results have changed, and results.html and index.html if anything changed at all.
+// The pages of events, clubs and athletes that are gone are removed.
+func publishResultPages(db *sqlx.DB) error {
+	if err := copyAssets(); err != nil {
+		return err
+	}
+
+	events, err := LoadResults(db)
+	if err != nil {
+		return err
+	}
+
+	clubs, athletes, err := LoadAthletes(db, events)
+	if err != nil {
+		return err
+	}
+
+	hashes := loadPageHashes()
+	current := make(map[string]bool)
+	changed := false
+
+	publish := func(name string, filename string, data interface{}) error {
+		current[filename] = true
+		published, err := hashes.publishChanged(name, filename, data)
+		changed = changed || published
+		return err
+	}
+
+	for _, event := range events {
+		if err := publish("event.html", eventFilename(event.ID), eventData(event)); err != nil {
+			return err
+		}
+	}
+
+	for _, club := range clubs {
+		if err := publish("club.html", club.Page, clubData(club)); err != nil {
+			return err
+		}
+	}
+
+	for _, athlete := range athletes {
+		if err := publish("athlete.html", athlete.Page, athleteData(athlete)); err != nil {
+			return err
+		}
+	}
+
+	if err := publish("index.html", "index.html", indexData(events, clubs, athletes)); err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	data["Events"] = events
+	if err := publish("results.html", "results.html", data); err != nil {
+		return err
+	}
+
+	removed, err := hashes.removeStale(current)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d pages that are no longer published.\n", removed)
+	}
+
+	if !changed && removed == 0 {
+		fmt.Println("No results have changed.")
+	}
+
+	return hashes.save()
+}
@@ -0,0 +1,70 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveStale(t *testing.T) {
+	saved := C
+	defer func() { C = saved }()
+	C.HTMLPath = t.TempDir()
+
+	files := []string{"event-1.html", "event-2.html", "club-CJRC.html", "club-OLD.html",
+		"athlete-7-smith.html", "athlete-8-jones.html", "index.html", "results.html", "schedule.html"}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(C.HTMLPath, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hashes := pageHashes{
+		"event-1.html":          "1",
+		"event-2.html":          "2",
+		"event-3.html":          "3", // its file is already gone
+		"club-OLD.html":         "4",
+		"athlete-8-jones.html":  "5",
+		"index.html":            "6",
+		"athlete-7-smith.html":  "7",
+		"club-CJRC.html":        "8",
+		"results.html":          "9",
+		"athlete-9-gone.html":   "10",
+		"unrelated-report.html": "11",
+	}
+	current := map[string]bool{"event-1.html": true, "club-CJRC.html": true, "athlete-7-smith.html": true,
+		"index.html": true, "results.html": true}
+
+	removed, err := hashes.removeStale(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 5 {
+		t.Errorf("removed %d pages, want 5", removed)
+	}
+
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(C.HTMLPath, f))
+		stale := f == "event-2.html" || f == "club-OLD.html" || f == "athlete-8-jones.html"
+		if stale && err == nil {
+			t.Errorf("%s wasn't removed", f)
+		}
+		if !stale && err != nil {
+			t.Errorf("%s was removed", f)
+		}
+	}
+
+	for _, f := range []string{"event-2.html", "event-3.html", "club-OLD.html", "athlete-8-jones.html", "athlete-9-gone.html"} {
+		if _, ok := hashes[f]; ok {
+			t.Errorf("the hash of %s wasn't forgotten", f)
+		}
+	}
+	for _, f := range []string{"event-1.html", "index.html", "results.html", "unrelated-report.html"} {
+		if _, ok := hashes[f]; !ok {
+			t.Errorf("the hash of %s was forgotten", f)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+var protest model.Protest
+var protestRuling string
+var protestDQ bool
+var protestAdjust time.Duration
+var protestRaceID int
+var protestListAll bool
+
+// protestCmd represents the protest command
+var protestCmd = &cobra.Command{
+	Use:   "protest",
+	Short: "File, rule on and close protests",
+	Long: `Protests against the results of an event, or of one bib in an event.
+
+An event with a protest that hasn't been closed shows as "Under protest" on the
+published results, and cannot be certified.`,
+}
+
+var protestFileCmd = &cobra.Command{
+	Use:   "file",
+	Short: "File a protest against an event or a bib",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := FileProtest(DBMustConnect(), &protest); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var protestRuleCmd = &cobra.Command{
+	Use:   "rule ID",
+	Short: "Rule on a protest",
+	Long: `Records the referee's ruling on a protest.  A ruling against a bib can
+disqualify it (--dq), or add a time penalty to its result (--adjust 5s).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Invalid protest id:", args[0])
+			os.Exit(1)
+		}
+
+		if err := RuleOnProtest(DBMustConnect(), id, protestRaceID, protestRuling, protestDQ, protestAdjust); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var protestCloseCmd = &cobra.Command{
+	Use:   "close ID",
+	Short: "Close a protest",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Invalid protest id:", args[0])
+			os.Exit(1)
+		}
+
+		if err := CloseProtest(DBMustConnect(), id); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var protestListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List protests that haven't been closed",
+	Run: func(cmd *cobra.Command, args []string) {
+		protests, err := model.LoadProtests(DBMustConnect(), protestListAll)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, p := range protests {
+			against := "whole event"
+			if p.BibNum != 0 {
+				against = "bib " + strconv.Itoa(p.BibNum)
+			}
+			fmt.Printf("#%d  event %d, %s  [%s]  filed by %s at %s\n",
+				p.ID, p.EventID, against, p.Status, p.FiledBy, p.FiledAt.In(C.Regatta.Location()).Format("03:04PM"))
+			fmt.Printf("     reason: %s\n", p.Reason)
+			if p.Status != model.ProtestOpen {
+				fmt.Printf("     ruling: %s\n", p.Ruling)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(protestCmd)
+	protestCmd.AddCommand(protestFileCmd)
+	protestCmd.AddCommand(protestRuleCmd)
+	protestCmd.AddCommand(protestCloseCmd)
+	protestCmd.AddCommand(protestListCmd)
+
+	protestFileCmd.Flags().IntVar(&protest.EventID, "event", 0, "Event number")
+	protestFileCmd.Flags().IntVar(&protest.BibNum, "bib", 0, "Bib number, if the protest is against one entry")
+	protestFileCmd.Flags().StringVar(&protest.FiledBy, "by", "", "Who filed the protest")
+	protestFileCmd.Flags().StringVar(&protest.Reason, "reason", "", "Reason for the protest")
+	protestFileCmd.MarkFlagRequired("event")
+	protestFileCmd.MarkFlagRequired("reason")
+
+	protestRuleCmd.Flags().StringVar(&protestRuling, "ruling", "", "The referee's ruling")
+	protestRuleCmd.Flags().BoolVar(&protestDQ, "dq", false, "Disqualify the protested bib")
+	protestRuleCmd.Flags().DurationVar(&protestAdjust, "adjust", 0, "Add this time penalty to the protested bib")
+	protestRuleCmd.Flags().IntVar(&protestRaceID, "race", 0, "Race of the result to penalize, if the bib raced more than once")
+	protestRuleCmd.MarkFlagRequired("ruling")
+
+	protestListCmd.Flags().BoolVar(&protestListAll, "all", false, "Include closed protests")
+}
+
+// FileProtest files a protest against an event, or a bib in the event.  The
+// results of a certified event can't be protested.
+func FileProtest(db model.DB, p *model.Protest) error {
+	event, err := findEvent(p.EventID)
+	if err != nil {
+		return err
+	}
+
+	err = model.Transact(db, func(tx *sqlx.Tx) error {
+		// so the event can't be certified while the protest is filed
+		if err := event.Lock(tx); err != nil {
+			return err
+		}
+
+		certs, err := model.LoadCertifications(tx)
+		if err != nil {
+			return err
+		}
+		if certs[event.ID].Certified {
+			return fmt.Errorf("event %d (%s) is certified, uncertify it before filing a protest", event.ID, event.Name)
+		}
+
+		if p.BibNum != 0 {
+			entry, err := model.LoadEntryByBib(tx, p.BibNum)
+			if err != nil {
+				return err
+			}
+			if entry.EventID != event.ID {
+				return fmt.Errorf("bib %d is not entered in event %d", p.BibNum, event.ID)
+			}
+		}
+
+		return p.Insert(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Filed protest #%d against event %d (%s).\n", p.ID, event.ID, event.Name)
+
+	return model.NotifyResults(db)
+}
+
+// RuleOnProtest records a ruling, and applies any disqualification or time
+// penalty to the protested bib.  A protest can only be ruled on while it is
+// open, so penalties can't be applied twice.  The penalty is applied to the
+// bib's result in the race, or to its only result if raceID is 0.
+func RuleOnProtest(db model.DB, id int, raceID int, ruling string, dq bool, adjustment time.Duration) error {
+	var p model.Protest
+	var result model.Result
+	var old time.Duration
+
+	err := model.Transact(db, func(tx *sqlx.Tx) error {
+		var err error
+		p, err = model.LoadProtest(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if p.Status != model.ProtestOpen {
+			return fmt.Errorf("protest #%d is %s, only open protests can be ruled on", p.ID, p.Status)
+		}
+
+		if (dq || adjustment != 0) && p.BibNum == 0 {
+			return fmt.Errorf("protest #%d is against the whole event, there is no bib to penalize", p.ID)
+		}
+
+		if dq || adjustment != 0 {
+			result, err = model.LoadResultByBib(tx, p.BibNum, raceID)
+			if err != nil {
+				return err
+			}
+			old = result.Time
+		}
+
+		if adjustment != 0 {
+			if err := result.SetTime(tx, result.Time+adjustment); err != nil {
+				return err
+			}
+		}
+
+		if dq {
+			if err := result.SetDQ(tx, true); err != nil {
+				return err
+			}
+		}
+
+		return p.Rule(tx, ruling, dq, adjustment)
+	})
+	if err != nil {
+		return err
+	}
+
+	if adjustment != 0 {
+		fmt.Printf("Adjusted time for %s (bib # %d) from %s to %s.\n",
+			result.Name, result.BibNum, durString(old), durString(result.Time))
+	}
+	if dq {
+		fmt.Printf("Disqualified %s (bib # %d).\n", result.Name, result.BibNum)
+	}
+	fmt.Printf("Ruled on protest #%d: %s\n", p.ID, ruling)
+
+	return model.NotifyResults(db)
+}
+
+// CloseProtest closes a protest, so the event can be certified
+func CloseProtest(db *sqlx.DB, id int) error {
+	p, err := model.LoadProtest(db, id)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Close(db); err != nil {
+		return err
+	}
+
+	fmt.Printf("Closed protest #%d.\n", p.ID)
+
+	return model.NotifyResults(db)
+}
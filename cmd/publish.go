@@ -6,6 +6,7 @@ package cmd
 import (
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path"
 	"sort"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/spf13/cobra"
 )
@@ -32,11 +34,13 @@ var publishResultsCmd = &cobra.Command{
 	Short: "Publish regatta results as an HTML file",
 	Long:  `Publishing process uses the results.html template to format results.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		db := DBMustConnect()
+
 		var err error
 		if publishLive {
-			err = PublishLiveResults()
+			err = PublishLiveResults(db)
 		} else {
-			err = PublishResults()
+			err = PublishResults(db)
 		}
 		if err != nil {
 			fmt.Println(err)
@@ -49,14 +53,21 @@ var publishResultsCmd = &cobra.Command{
 var publishScheduleCmd = &cobra.Command{
 	Use:   "schedule",
 	Short: "Publish the regatta schedule as an HTML file",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Long: `Publishing process uses the schedule.html template to format the
+scheduled races and their entries.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("publish called")
+		db := DBMustConnect()
+
+		var err error
+		if publishLive {
+			err = PublishLiveSchedule(db)
+		} else {
+			err = PublishSchedule(db)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -64,14 +75,13 @@ to quickly create a Cobra application.`,
 var publishRacesCmd = &cobra.Command{
 	Use:   "races",
 	Short: "Publish the .RAC files for Venue Racing Application",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Long: `Writes one .RAC file for every scheduled race to the RacePath.  The files
+can be opened by the Concept 2 Venue Racing Application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("publish called")
+		if err := PublishRaces(DBMustConnect()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -82,6 +92,7 @@ func init() {
 	publishCmd.AddCommand(publishScheduleCmd)
 
 	publishResultsCmd.Flags().BoolVar(&publishLive, "live", false, "Publish live results in realtime.")
+	publishScheduleCmd.Flags().BoolVar(&publishLive, "live", false, "Republish the schedule as entries change.")
 }
 
 func durString(d time.Duration) string {
@@ -93,40 +104,9 @@ func durString(d time.Duration) string {
 	return fmt.Sprintf("%d:%04.1f", mins, secs)
 }
 
-// PublishResults creates a nice HTML view of the results in the folder specified by path
-// TODO: Imported from indoor-2019, fix it up
-func PublishResults() error {
-
-	// Events sorted by their event number
-	var events = append([]model.Event(nil), C.Events...)
-
-	sort.Slice(events, func(h, k int) bool {
-		return events[h].ID < events[k].ID
-	})
-
-	db := DBMustConnect()
-
-	for i := range events {
-		// Load the entries for this event
-		if err := events[i].LoadEntriesWithResults(db); err != nil {
-			return err
-		}
-
-		// Sort entries and give each result a finish place
-		model.AssignPlacesToEntries(events[i].Entries)
-	}
-
-	// create the HTML results file
-	fullname := path.Join(C.HTMLPath, "results.html")
-	file, err := os.Create(fullname)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	fmt.Println("Publishing results to", fullname)
-
-	funcMap := template.FuncMap{
+// templateFuncs are the helper functions available to every HTML template
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
 		"inc": func(i int) int {
 			return i + 1
 		},
@@ -134,20 +114,25 @@ func PublishResults() error {
 			return (i % 2) == 0
 		},
 		"official": func(e model.Event) template.HTML {
-			//TODO FIX THIS
-			// if e.Official {
-			// 	return template.HTML("Official")
-			// }
+			if e.UnderProtest {
+				return template.HTML("<i>Under protest</i>")
+			}
+			if e.Official {
+				return template.HTML("Official " + e.OfficialAt.In(C.Regatta.Location()).Format("Jan 2 at 03:04PM"))
+			}
 			return template.HTML("<i>Unofficial</i>")
 		},
 		"place": func(e model.Entry) string {
+			if e.Result.DQ {
+				return "DQ"
+			}
 			if e.Result.Time == 0 {
 				return "-"
 			}
 			return strconv.Itoa(e.Result.Place)
 		},
 		"now": func() string {
-			return time.Now().Format("Jan 2, 2006 at 03:04PM")
+			return time.Now().In(C.Regatta.Location()).Format("Jan 2, 2006 at 03:04PM")
 		},
 		"ltwt": func(entry model.Entry) string {
 			if entry.Ltwt {
@@ -161,28 +146,144 @@ func PublishResults() error {
 			}
 			return durString(entry.Result.Time)
 		},
+		"pageSlug": pageSlug,
+		"regatta": func() Regatta {
+			return C.Regatta
+		},
+		// live is replaced by 'race serve' with the script that keeps the page up to date
+		"live": func() template.HTML {
+			return ""
+		},
 	}
+}
+
+// executeTemplate parses the named template from the TemplatePath and
+// writes it to w using the supplied data
+func executeTemplate(w io.Writer, name string, data interface{}) error {
+	return executeTemplateFuncs(w, name, data, templateFuncs())
+}
 
-	templatePath := path.Join(C.TemplatePath, "results.html")
-	t, err := template.New(path.Base(templatePath)).Funcs(funcMap).ParseFiles(templatePath)
+// executeTemplateFuncs is executeTemplate with a custom set of template functions
+func executeTemplateFuncs(w io.Writer, name string, data interface{}, funcs template.FuncMap) error {
+	templatePath := path.Join(C.TemplatePath, name)
+	t, err := template.New(path.Base(templatePath)).Funcs(funcs).ParseFiles(templatePath)
 	if err != nil {
 		return err
 	}
 
+	return t.Execute(w, data)
+}
+
+// pageLoader loads the data that a template needs to render a page
+type pageLoader func(db *sqlx.DB) (map[string]interface{}, error)
+
+// pages maps each published template to the loader for its data
+var pages = map[string]pageLoader{
+	"results.html":    resultsPage,
+	"schedule.html":   schedulePage,
+	"startlists.html": startlistsPage,
+	"banksheets.html": startlistsPage,
+	"bibs.html":       bibsPage,
+	"lanecards.html":  lanecardsPage,
+	"announcer.html":  announcerPage,
+	"display.html":    displayPage,
+	"index.html":      indexPage,
+}
+
+// publishPage loads the data for the named page and publishes it to the HTMLPath
+func publishPage(db *sqlx.DB, name string) error {
+	if err := copyAssets(); err != nil {
+		return err
+	}
+
+	data, err := pages[name](db)
+	if err != nil {
+		return err
+	}
+
+	return publishHTML(name, data)
+}
+
+// publishHTML renders the named template into a file of the same name in the HTMLPath
+func publishHTML(name string, data interface{}) error {
+	return publishTemplate(name, name, data)
+}
+
+// publishTemplate renders the named template into the filename in the HTMLPath.
+// The file is replaced atomically, so viewers never load half a page.
+func publishTemplate(name string, filename string, data interface{}) error {
+	fullname := path.Join(C.HTMLPath, filename)
+
+	fmt.Println("Publishing", filename, "to", fullname)
+
+	return writeFileAtomic(fullname, func(w io.Writer) error {
+		return executeTemplate(w, name, data)
+	})
+}
+
+// LoadResults returns the configured events, sorted by their event number,
+// with each event's entries loaded and placed by finishing time
+func LoadResults(db *sqlx.DB) ([]model.Event, error) {
+	// Events sorted by their event number
+	var events = append([]model.Event(nil), C.Events...)
+
+	sort.Slice(events, func(h, k int) bool {
+		return events[h].ID < events[k].ID
+	})
+
+	certs, err := model.LoadCertifications(db)
+	if err != nil {
+		return nil, err
+	}
+
+	protested, err := model.EventsUnderProtest(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		// Load the entries for this event
+		if err := events[i].LoadEntriesWithResults(db); err != nil {
+			return nil, err
+		}
+
+		// Sort entries and give each result a finish place
+		model.AssignPlacesToEntries(events[i].Entries)
+
+		cert := certs[events[i].ID]
+		events[i].Official = cert.Certified
+		events[i].OfficialAt = cert.At
+		events[i].UnderProtest = protested[events[i].ID]
+	}
+
+	return events, nil
+}
+
+func resultsPage(db *sqlx.DB) (map[string]interface{}, error) {
+	events, err := LoadResults(db)
+	if err != nil {
+		return nil, err
+	}
+
 	data := make(map[string]interface{})
 	data["Events"] = events
-	err = t.Execute(file, data)
 
-	return err
+	return data, nil
+}
 
+// PublishResults creates a nice HTML view of the results in the folder specified by path.
+// Along with results.html, every event, club and athlete has its own page, and
+// there is an index.html.  Pages are only rendered again if their content has changed.
+func PublishResults(db *sqlx.DB) error {
+	return publishResultPages(db)
 }
 
-func waitForResults(l *pq.Listener) error {
+func waitForResults(l *pq.Listener, publish func() error) error {
 	for {
 		fmt.Println("Listening for live results...")
 		select {
 		case <-l.Notify:
-			if err := PublishResults(); err != nil {
+			if err := publish(); err != nil {
 				return err
 			}
 		case <-time.After(5 * time.Minute):
@@ -195,10 +296,11 @@ func waitForResults(l *pq.Listener) error {
 	}
 }
 
-// PublishLiveResults will publish HTML results as they arrive at the database
-func PublishLiveResults() error {
-	// publish existing results
-	if err := PublishResults(); err != nil {
+// publishLiveOn runs publish once, and then again every time one of the
+// named channels is notified by the database
+func publishLiveOn(publish func() error, channels ...string) error {
+	// publish what is already there
+	if err := publish(); err != nil {
 		return err
 	}
 
@@ -211,13 +313,132 @@ func PublishLiveResults() error {
 	listener := pq.NewListener(C.DB, 10*time.Second, time.Minute, reportProblem)
 	defer listener.Close()
 
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			return err
+		}
+	}
+
+	return waitForResults(listener, publish)
+}
+
+// PublishLiveResults will publish HTML results as they arrive at the database
+func PublishLiveResults(db *sqlx.DB) error {
 	// listen for changes to results and entries
-	if err := listener.Listen("results"); err != nil {
+	return publishLiveOn(func() error { return PublishResults(db) }, "results", "entries")
+}
+
+// ScheduleRace is a scheduled race as shown by the schedule.html template
+type ScheduleRace struct {
+	ID       int
+	Name     string
+	Bank     string
+	Distance uint
+	NLanes   uint
+	Start    string
+	Entries  []model.Entry
+
+	StartTime time.Time
+	Completed bool // the race's results have been imported
+}
+
+// LoadSchedule builds the view of every scheduled race and the entries
+// racing in it, ordered by start time
+func LoadSchedule(db *sqlx.DB) ([]ScheduleRace, error) {
+	races, err := model.LoadRaces(db)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := model.LoadScheduledEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	byRace := make(map[int][]model.Entry)
+	for _, entry := range entries {
+		byRace[entry.RaceID] = append(byRace[entry.RaceID], entry)
+	}
+
+	schedule := make([]ScheduleRace, len(races))
+	for i, race := range races {
+		schedule[i] = ScheduleRace{
+			ID:       race.ID,
+			Name:     race.Name,
+			Bank:     race.Bank,
+			Distance: race.Distance,
+			NLanes:   race.NLanes,
+			Start:    race.StartTime.In(C.Regatta.Location()).Format("3:04PM"),
+			Entries:  byRace[race.ID],
+
+			StartTime: race.StartTime,
+			Completed: race.CompletedAt != nil,
+		}
+	}
+
+	return schedule, nil
+}
+
+func schedulePage(db *sqlx.DB) (map[string]interface{}, error) {
+	races, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{})
+	data["Races"] = races
+
+	return data, nil
+}
+
+// PublishSchedule creates an HTML view of the scheduled races in the HTMLPath
+func PublishSchedule(db *sqlx.DB) error {
+	return publishPage(db, "schedule.html")
+}
+
+// PublishLiveSchedule will republish the HTML schedule whenever entries change,
+// or results arrive and a race is done
+func PublishLiveSchedule(db *sqlx.DB) error {
+	return publishLiveOn(func() error { return PublishSchedule(db) }, "entries", "results")
+}
+
+// raceFilename is where the .RAC file for a race is published
+func raceFilename(race model.Race) string {
+	return path.Join(C.RacePath, fmt.Sprintf("race%03d.rac", race.ID))
+}
+
+// PublishRace writes the .RAC file for one race to the RacePath
+func PublishRace(db *sqlx.DB, raceID int) error {
+	race, err := model.LoadRace(db, raceID)
+	if err != nil {
+		return err
+	}
+
+	return publishRace(db, race)
+}
+
+func publishRace(db *sqlx.DB, race model.Race) error {
+	if err := race.LoadBoats(db); err != nil {
 		return err
 	}
-	if err := listener.Listen("entries"); err != nil {
+
+	filename := raceFilename(race)
+	fmt.Printf("Publishing race %d to %s\n", race.ID, filename)
+	return race.WriteToFile(filename)
+}
+
+// PublishRaces writes a .RAC file for every scheduled race to the RacePath
+func PublishRaces(db *sqlx.DB) error {
+	races, err := model.LoadRaces(db)
+	if err != nil {
 		return err
 	}
 
-	return waitForResults(listener)
+	for _, race := range races {
+		if err := publishRace(db, race); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,175 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cjrc/race/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+// The kinds of discrepancy between the results and the schedule
+const (
+	kindNotEntered   = "not entered"
+	kindScratched    = "scratched"
+	kindWrongRace    = "wrong race"
+	kindWrongLane    = "wrong lane"
+	kindWrongLength  = "wrong distance"
+	kindNoResult     = "no result"
+	kindNameMismatch = "name differs"
+)
+
+// Discrepancy is one problem found by reconciling results with the schedule
+type Discrepancy struct {
+	BibNum int
+	RaceID int // the race the bib is scheduled in, or 0
+	Kind   string
+	Detail string
+}
+
+var checkResultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Reconcile results with the scheduled races and entries",
+	Long: `Cross checks every result against the entries and the scheduled races, and
+lists each discrepancy for the head referee:
+
+  not entered     a result for a bib that isn't entered
+  scratched       a result for a bib that was scratched
+  wrong race      a result from a race the bib isn't scheduled in
+  wrong lane      a bib that raced in a different lane than scheduled
+  wrong distance  a result for a different distance than the race
+  no result       a scheduled entry with no result, in a race that has results
+  name differs    a name in the results file that isn't the entry's boat name`,
+	Run: func(cmd *cobra.Command, args []string) {
+		discrepancies, err := ReconcileResults(DBMustConnect())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, d := range discrepancies {
+			race := "-"
+			if d.RaceID != 0 {
+				race = fmt.Sprint(d.RaceID)
+			}
+			fmt.Printf("race %-4s bib %-5d %-15s %s\n", race, d.BibNum, d.Kind, d.Detail)
+		}
+
+		if len(discrepancies) == 0 {
+			fmt.Println("The results match the schedule.")
+		} else {
+			fmt.Printf("%d discrepancies.\n", len(discrepancies))
+		}
+	},
+}
+
+func init() {
+	checkCmd.AddCommand(checkResultsCmd)
+}
+
+// sameName reports if the name in the results file is the entry's boat name.
+// Case and spacing are ignored, and the results may have a truncated name.
+func sameName(resultName string, boatName string) bool {
+	r := strings.ToLower(strings.Join(strings.Fields(resultName), " "))
+	b := strings.ToLower(strings.Join(strings.Fields(boatName), " "))
+	return r != "" && strings.HasPrefix(b, r)
+}
+
+// ReconcileResults cross checks the results against the entries and the
+// scheduled races.  Discrepancies are sorted by race, then bib.
+func ReconcileResults(db *sqlx.DB) ([]Discrepancy, error) {
+	results, err := model.LoadAllResults(db)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := model.LoadEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[int]model.Entry, len(all))
+	for _, e := range all {
+		entries[e.BibNum] = e
+	}
+
+	races, err := model.LoadRaces(db)
+	if err != nil {
+		return nil, err
+	}
+	racesByID := make(map[int]model.Race, len(races))
+	for _, race := range races {
+		racesByID[race.ID] = race
+	}
+
+	var found []Discrepancy
+	add := func(bib int, raceID int, kind string, format string, a ...interface{}) {
+		found = append(found, Discrepancy{BibNum: bib, RaceID: raceID, Kind: kind, Detail: fmt.Sprintf(format, a...)})
+	}
+
+	raced := make(map[int]bool) // races that have results
+	for _, result := range results {
+		bib := result.BibNum
+		entry, ok := entries[bib]
+		if !ok {
+			add(bib, 0, kindNotEntered, "%s has a result, in %s", result.Name, result.Source)
+			continue
+		}
+		if result.RaceID != 0 {
+			raced[result.RaceID] = true
+		} else {
+			raced[entry.RaceID] = true
+		}
+
+		if entry.Scratched {
+			add(bib, entry.RaceID, kindScratched, "%s was scratched, but has a result", entry.BoatName)
+		}
+
+		// results imported before races were recorded have no race to check
+		if result.RaceID != 0 && result.RaceID != entry.RaceID {
+			if entry.RaceID == 0 {
+				add(bib, 0, kindWrongRace, "%s isn't scheduled, but raced in race %d (%s)", entry.BoatName, result.RaceID, result.Source)
+			} else {
+				add(bib, entry.RaceID, kindWrongRace, "%s raced in race %d (%s)", entry.BoatName, result.RaceID, result.Source)
+			}
+		}
+
+		if result.Lane != 0 && entry.RaceID != 0 && result.Lane != entry.Lane {
+			add(bib, entry.RaceID, kindWrongLane, "%s raced in lane %d, scheduled in lane %d", entry.BoatName, result.Lane, entry.Lane)
+		}
+
+		if race, ok := racesByID[entry.RaceID]; ok && result.Distance != 0 && uint(result.Distance) != race.Distance {
+			add(bib, entry.RaceID, kindWrongLength, "%s rowed %dm, race %d is %dm", entry.BoatName, result.Distance, race.ID, race.Distance)
+		}
+
+		if !sameName(result.Name, entry.BoatName) {
+			add(bib, entry.RaceID, kindNameMismatch, "results say %q, entry is %q", result.Name, entry.BoatName)
+		}
+	}
+
+	for _, entry := range all {
+		if entry.RaceID == 0 || entry.Scratched || !raced[entry.RaceID] {
+			continue
+		}
+		if _, ok := results.InRace(entry.RaceID, entry.BibNum); !ok {
+			add(entry.BibNum, entry.RaceID, kindNoResult, "%s in lane %d has no result", entry.BoatName, entry.Lane)
+		}
+	}
+
+	sort.Slice(found, func(h, k int) bool {
+		if found[h].RaceID != found[k].RaceID {
+			return found[h].RaceID < found[k].RaceID
+		}
+		if found[h].BibNum != found[k].BibNum {
+			return found[h].BibNum < found[k].BibNum
+		}
+		return found[h].Kind < found[k].Kind
+	})
+
+	return found, nil
+}
@@ -0,0 +1,99 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/cjrc/race/model"
+	"github.com/spf13/cobra"
+)
+
+var historyByAthlete bool
+
+// regattasCmd represents the regattas command
+var regattasCmd = &cobra.Command{
+	Use:   "regattas",
+	Short: "List every regatta in the database",
+	Long: `Lists every regatta in the database.  Commands work on the regatta whose
+id is set as Regatta.ID in race.yaml.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		regattas, err := model.LoadRegattas(DBMustConnect())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, r := range regattas {
+			current := " "
+			if r.ID == C.Regatta.ID {
+				current = "*"
+			}
+			fmt.Printf("%s %3d  %-40s %-20s %s\n", current, r.ID, r.Name, r.Date, r.Venue)
+		}
+	},
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history NAME",
+	Short: "Show an athlete's results from every regatta",
+	Long: `Shows the results, from every regatta in the database, of the entries
+whose boat name contains NAME.  Useful for seeding, and for checking records.
+
+With --athlete, NAME is an athlete id, and their personal bests are shown too.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := DBMustConnect()
+
+		var history []model.HistoryResult
+		var err error
+		if historyByAthlete {
+			id, convErr := strconv.Atoi(args[0])
+			if convErr != nil {
+				fmt.Println("Invalid athlete id:", args[0])
+				os.Exit(1)
+			}
+			history, err = model.LoadAthleteHistory(db, id)
+		} else {
+			history, err = model.LoadHistory(db, args[0])
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, h := range history {
+			result := durString(h.Time)
+			if h.DQ {
+				result = "DQ"
+			}
+			fmt.Printf("%-30s %-24s %-6s event %2d  %5dm  %s\n",
+				h.RegattaName, h.BoatName, h.ClubAbbrev, h.EventID, h.Distance, result)
+		}
+
+		if historyByAthlete {
+			bests := model.PersonalBests(history)
+			distances := make([]int, 0, len(bests))
+			for d := range bests {
+				distances = append(distances, d)
+			}
+			sort.Ints(distances)
+
+			for _, d := range distances {
+				fmt.Printf("Personal best for %dm: %s\n", d, durString(bests[d]))
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(regattasCmd)
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().BoolVar(&historyByAthlete, "athlete", false, "NAME is an athlete id")
+}
@@ -4,9 +4,14 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"net/url"
 	"os"
+	"os/user"
+	"strings"
 
+	"github.com/cjrc/race/model"
 	"github.com/jmoiron/sqlx"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,6 +20,9 @@ import (
 var cfgFile string
 var dbString string
 
+// commandName is the command being run, like "race move", for the change log
+var commandName string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "race",
@@ -25,6 +33,10 @@ examples and usage of using your application. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		commandName = cmd.CommandPath()
+		mustValidateConfig(cmd)
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
@@ -39,9 +51,72 @@ func Execute() {
 	}
 }
 
+// uncheckedConfig annotates the commands that run without a valid config,
+// because they create, show or check it
+var uncheckedConfig = map[string]string{"config": "unchecked"}
+
+// mustValidateConfig stops the command if the config has problems
+func mustValidateConfig(cmd *cobra.Command) {
+	if cmd.Annotations["config"] == "unchecked" || cmd.Name() == "help" {
+		return
+	}
+
+	errs := C.Validate()
+	if len(errs) == 0 {
+		return
+	}
+
+	fmt.Println("The config has problems:")
+	for _, err := range errs {
+		fmt.Println("  " + err.Error())
+	}
+	fmt.Println("Fix them in race.yaml, and check with 'race check config'.")
+	os.Exit(1)
+}
+
 // DBConnect connects to the application specified database
 func DBConnect() (*sqlx.DB, error) {
-	return sqlx.Connect("postgres", C.DB)
+	return sqlx.Connect("postgres", auditDSN(C.DB))
+}
+
+// currentOperator returns who is running the race command, the configured
+// Operator or else the OS user
+func currentOperator() string {
+	if C.Operator != "" {
+		return C.Operator
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// auditDSN adds who is running which command to the connection settings, so
+// the change log triggers can record them
+func auditDSN(dsn string) string {
+	settings := map[string]string{
+		"race.operator": currentOperator(),
+		"race.command":  commandName,
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn
+		}
+		q := u.Query()
+		for k, v := range settings {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for k, v := range settings {
+		dsn += fmt.Sprintf(" %s='%s'", k, quote.Replace(v))
+	}
+	return dsn
 }
 
 // DBMustConnect returns a connection to the database.
@@ -55,6 +130,15 @@ func DBMustConnect() *sqlx.DB {
 	return db
 }
 
+// verifyPrintf asks the operator a yes or no question, and reports if they
+// answered yes
+func verifyPrintf(format string, a ...interface{}) bool {
+	fmt.Printf(format+" [y/N] ", a...)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -106,4 +190,7 @@ func initConfig() {
 	if dbString != "" {
 		C.DB = dbString
 	}
+
+	// every query is scoped to the configured regatta
+	model.RegattaID = C.Regatta.ID
 }
@@ -0,0 +1,258 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/spf13/cobra"
+)
+
+// liveScript is added to served pages by the 'live' template function.
+// It reloads the page whenever the server pushes an update.
+const liveScript = `<script>
+    (function () {
+        if (!window.EventSource) {
+            return
+        }
+        var reload = null
+        var source = new EventSource("/events")
+        source.onmessage = function () {
+            // several notifications often arrive together, only reload once
+            clearTimeout(reload)
+            reload = setTimeout(function () { window.location.reload() }, 1000)
+        }
+    })()
+</script>`
+
+var serveAddr string
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve live schedule and results over HTTP",
+	Long: `The serve command runs a web server that renders the templates in the
+TemplatePath every time a page is requested, so pages are always current.
+
+Browsers viewing a page are told when results or entries change in the
+database, and reload the page by themselves.  Any other file is served from
+the HTMLPath.
+
+The JSON API is served under /api/v1/.  Anyone can read events, entries, races
+and results.  Scratches, lane moves and result adjustments need the APIToken
+from the config, sent as "Authorization: Bearer <token>".  The change log
+records who made each change from the request's X-Race-Operator header, or
+"api" if it has none.
+
+The announcer dashboard is served at /announcer.html, and the full screen
+venue display at /display.html (add ?dwell=15 to change how many seconds each
+slide is shown).
+
+The race day admin console is served at /admin.  It uses the same API, and
+asks for the APIToken, and your name for the change log, before making any
+change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Serve(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "", "Address to listen on (default is ServeAddr from the config)")
+}
+
+// broker passes database notifications to every browser that is listening
+type broker struct {
+	mu      sync.Mutex
+	clients map[chan string]bool
+}
+
+func newBroker() *broker {
+	return &broker{clients: make(map[chan string]bool)}
+}
+
+func (b *broker) subscribe() chan string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan string, 1)
+	b.clients[ch] = true
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.clients, ch)
+}
+
+// publish sends msg to every client.  A client that already has an update
+// waiting doesn't need another one, so publish never blocks.
+func (b *broker) publish(msg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams updates to the browser as Server-Sent Events
+func (b *broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	for {
+		select {
+		case msg := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			flusher.Flush()
+		case <-time.After(30 * time.Second):
+			// keep proxies from closing an idle connection
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// listen passes the notifications on the named database channels to the broker
+func (b *broker) listen(channels ...string) error {
+	reportProblem := func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+
+	listener := pq.NewListener(C.DB, 10*time.Second, time.Minute, reportProblem)
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case n := <-listener.Notify:
+				// a nil notification means the connection was re-established,
+				// and notifications may have been missed
+				if n == nil {
+					b.publish("reconnect")
+				} else {
+					b.publish(n.Channel)
+				}
+			case <-time.After(5 * time.Minute):
+				if err := listener.Ping(); err != nil {
+					fmt.Println("Database listener:", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// pageHandler renders the templates in pages on every request, and serves
+// everything else from the HTMLPath
+func pageHandler(db *sqlx.DB) http.Handler {
+	files := http.FileServer(http.Dir(C.HTMLPath))
+
+	funcs := templateFuncs()
+	funcs["live"] = func() template.HTML {
+		return template.HTML(liveScript)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == "" {
+			name = "index.html"
+		}
+
+		tmpl, load, ok := lookupPage(name)
+		if !ok {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		data, err := load(db)
+		if err != nil {
+			fmt.Println("Error loading", name+":", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// render to a buffer, so a failed template doesn't send half a page
+		var buf bytes.Buffer
+		if err := executeTemplateFuncs(&buf, tmpl, data, funcs); err != nil {
+			fmt.Println("Error rendering", name+":", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		buf.WriteTo(w)
+	})
+}
+
+// Serve runs the live web server until it fails
+func Serve() error {
+	// The command line flag overrides the config file
+	if serveAddr != "" {
+		C.ServeAddr = serveAddr
+	}
+
+	db := DBMustConnect()
+
+	// the pages served from the HTMLPath need the stylesheets and scripts
+	if err := copyAssets(); err != nil {
+		return err
+	}
+
+	events := newBroker()
+	if err := events.listen("results", "entries"); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", events)
+	mux.Handle(APIPrefix, apiHandler(db))
+	mux.HandleFunc("/admin", adminHandler)
+	mux.Handle("/", pageHandler(db))
+
+	fmt.Println("Serving live results on", C.ServeAddr)
+	return http.ListenAndServe(C.ServeAddr, mux)
+}
@@ -0,0 +1,87 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+// publishStartlistsCmd represents the publish startlists command
+var publishStartlistsCmd = &cobra.Command{
+	Use:   "startlists",
+	Short: "Publish printable start lists and bank master sheets",
+	Long: `Publishes printable HTML for the marshals and erg-side officials.
+
+startlists.html has one page per race with the lane, bib, name and club of
+every entry.  banksheets.html has one master sheet per bank listing every race
+on that bank.  Both are formatted by templates of the same name in the
+TemplatePath, and print with a page break between sheets.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := PublishStartlists(DBMustConnect()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	publishCmd.AddCommand(publishStartlistsCmd)
+}
+
+// BankSheet is every scheduled race on one bank of ergs
+type BankSheet struct {
+	Bank  string
+	Races []ScheduleRace
+}
+
+// groupRacesByBank returns the races grouped by bank, banks sorted by name.
+// Races keep their scheduled order within each bank.
+func groupRacesByBank(races []ScheduleRace) []BankSheet {
+	var banks []BankSheet
+	index := make(map[string]int)
+
+	for _, race := range races {
+		i, ok := index[race.Bank]
+		if !ok {
+			i = len(banks)
+			index[race.Bank] = i
+			banks = append(banks, BankSheet{Bank: race.Bank})
+		}
+		banks[i].Races = append(banks[i].Races, race)
+	}
+
+	sort.SliceStable(banks, func(h, k int) bool {
+		return banks[h].Bank < banks[k].Bank
+	})
+
+	return banks
+}
+
+func startlistsPage(db *sqlx.DB) (map[string]interface{}, error) {
+	races, err := LoadSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{})
+	data["Races"] = races
+	data["Banks"] = groupRacesByBank(races)
+
+	return data, nil
+}
+
+// PublishStartlists creates the printable per race start lists and
+// the per bank master sheets in the HTMLPath
+func PublishStartlists(db *sqlx.DB) error {
+	if err := publishPage(db, "startlists.html"); err != nil {
+		return err
+	}
+
+	return publishPage(db, "banksheets.html")
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 CJRC, Inc <greg@jrc.us>
+//
+
+// Package testdb gives the tests that need Postgres a schema of their own.
+package testdb
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // database driver for Postgres
+)
+
+// DSN creates an empty schema in the Postgres database in RACE_TEST_DB, and
+// returns a dsn whose connections all use it.  The schema is dropped when
+// the test ends.  The test is skipped when RACE_TEST_DB isn't set.
+func DSN(t testing.TB) string {
+	t.Helper()
+
+	dsn := os.Getenv("RACE_TEST_DB")
+	if dsn == "" {
+		t.Skip("RACE_TEST_DB is not set")
+	}
+
+	admin, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("race_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	return withSearchPath(dsn, schema)
+}
+
+// withSearchPath makes every connection of the dsn use the schema
+func withSearchPath(dsn string, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// archiveTable is a table in a regatta archive, and which of its rows belong
+// to the regatta
+type archiveTable struct {
+	Name   string
+	Where  string // $1 is the regatta
+	Serial bool   // has a SERIAL id, whose sequence is reset on restore
+}
+
+// regattaAthletes are the athletes with an entry in the regatta
+const regattaAthletes = "(SELECT athlete_id FROM entries WHERE regatta_id=$1)"
+
+// archiveTables are the tables in an archive, in the order they are restored
+var archiveTables = []archiveTable{
+	{"regattas", "id=$1", true},
+	{"athletes", "id IN " + regattaAthletes, true},
+	{"distinctathletes", "athlete_id IN " + regattaAthletes + " AND other_id IN " + regattaAthletes, false},
+	{"races", "regatta_id=$1", true},
+	{"entries", "regatta_id=$1", true},
+	{"results", "regatta_id=$1", true},
+	{"imports", "regatta_id=$1", true},
+	{"certifications", "regatta_id=$1", true},
+	{"protests", "regatta_id=$1", true},
+	{"notifications", "regatta_id=$1", true},
+	{"changes", "regatta_id=$1", true},
+}
+
+// ArchiveRegatta returns every row of the regatta, as a JSON array for each table
+func ArchiveRegatta(db *sqlx.DB) (map[string]json.RawMessage, error) {
+	tables := make(map[string]json.RawMessage)
+	for _, t := range archiveTables {
+		var rows []byte
+		sql := fmt.Sprintf("SELECT COALESCE(json_agg(t ORDER BY %s), '[]') FROM %s t WHERE %s", orderBy(t), t.Name, t.Where)
+		if err := db.Get(&rows, sql, RegattaID); err != nil {
+			return nil, fmt.Errorf("cannot archive %s: %v", t.Name, err)
+		}
+		tables[t.Name] = rows
+	}
+	return tables, nil
+}
+
+func orderBy(t archiveTable) string {
+	if t.Serial {
+		return "t.id"
+	}
+	return "t.athlete_id, t.other_id"
+}
+
+// RestoreRegatta adds the archived rows to the database, keeping their ids.
+// The database must not already have them, so it should be a new database.
+// Either every row is restored, or none are.
+func RestoreRegatta(db *sqlx.DB, tables map[string]json.RawMessage) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the archive has the change log, restoring isn't a change
+	for _, name := range []string{"entries", "results", "races"} {
+		if _, err := tx.Exec("ALTER TABLE " + name + " DISABLE TRIGGER USER"); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range archiveTables {
+		rows, ok := tables[t.Name]
+		if !ok {
+			continue
+		}
+
+		sql := fmt.Sprintf("INSERT INTO %s SELECT * FROM json_populate_recordset(NULL::%s, $1)", t.Name, t.Name)
+		if _, err := tx.Exec(sql, string(rows)); err != nil {
+			return fmt.Errorf("cannot restore %s: %v", t.Name, err)
+		}
+
+		if t.Serial {
+			sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(max(id), 0) + 1, false) FROM %s", t.Name, t.Name)
+			if _, err := tx.Exec(sql); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range []string{"entries", "results", "races"} {
+		if _, err := tx.Exec("ALTER TABLE " + name + " ENABLE TRIGGER USER"); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,282 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AthleteSchema is the sql commands to create the Athletes table.  Athletes
+// aren't scoped to a regatta, the same athlete is linked to their entries in
+// every regatta.
+var AthleteSchema = []string{
+	`CREATE TABLE Athletes (
+		id SERIAL PRIMARY KEY,
+		name TEXT DEFAULT ''::text,
+		email TEXT DEFAULT ''::text,
+		dob TEXT DEFAULT ''::text,
+		created_at TIMESTAMPTZ DEFAULT now()
+	);`,
+	"CREATE INDEX ON Athletes (lower(name));",
+	`CREATE TABLE DistinctAthletes (
+		athlete_id INTEGER,
+		other_id INTEGER,
+		PRIMARY KEY (athlete_id, other_id)
+	);`,
+}
+
+// Athlete is one person, who may have entries in many events and regattas
+type Athlete struct {
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	Email     string    `db:"email"`
+	DOB       string    `db:"dob"` // YYYY-MM-DD, or empty if unknown
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// AthletePair is two athletes that might be the same person
+type AthletePair struct {
+	Athlete Athlete `db:"a"`
+	Other   Athlete `db:"b"`
+}
+
+// normalizeName is how names are compared when matching athletes
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// Insert adds the athlete, and sets its ID
+func (a *Athlete) Insert(db *sqlx.DB) error {
+	sql := `INSERT INTO Athletes(name, email, dob)
+		VALUES($1, $2, $3) RETURNING id`
+
+	return db.Get(&a.ID, sql, a.Name, a.Email, a.DOB)
+}
+
+// LoadAthlete returns the athlete with the specified id
+func LoadAthlete(db *sqlx.DB, id int) (a Athlete, err error) {
+	err = db.Get(&a, "SELECT * FROM athletes WHERE id=$1", id)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("there is no athlete %d", id)
+	}
+	return
+}
+
+// matches reports if the entry is certainly this athlete, and if it
+// certainly isn't.  The names are assumed to be the same.
+func (a Athlete) matches(entry Entry) (same bool, different bool) {
+	if a.DOB != "" && entry.DOB != "" {
+		return a.DOB == entry.DOB, a.DOB != entry.DOB
+	}
+	if a.Email != "" && strings.EqualFold(a.Email, entry.Email) {
+		return true, false
+	}
+	return false, false
+}
+
+// matchAthlete finds the athlete for an entry.  If the entry could belong to
+// more than one athlete, or to an athlete it can't be told apart from, it is
+// ambiguous, and the entry should get a new athlete for review.
+func matchAthlete(athletes []Athlete, entry Entry) (match Athlete, ambiguous bool) {
+	var same, maybe []Athlete
+
+	name := normalizeName(entry.BoatName)
+	for _, a := range athletes {
+		if normalizeName(a.Name) != name {
+			continue
+		}
+
+		isSame, isDifferent := a.matches(entry)
+		if isSame {
+			same = append(same, a)
+		} else if !isDifferent {
+			maybe = append(maybe, a)
+		}
+	}
+
+	if len(same) == 1 {
+		return same[0], false
+	}
+	return Athlete{}, len(same) > 1 || len(maybe) > 0
+}
+
+// LinkAthletes links every entry in the regatta that has no athlete.  An entry
+// is linked to the athlete with the same name and the same date of birth or
+// email, otherwise a new athlete is created.  It returns how many entries were
+// linked to an existing athlete, how many new athletes were created, and how
+// many of those are ambiguous and need 'race athletes review'.
+func LinkAthletes(db *sqlx.DB) (linked int, created int, ambiguous int, err error) {
+	var entries []Entry
+	err = db.Select(&entries, "SELECT * FROM entries WHERE regatta_id=$1 AND athlete_id=0 ORDER BY bib_num", RegattaID)
+	if err != nil {
+		return
+	}
+
+	var athletes []Athlete
+	if err = db.Select(&athletes, "SELECT * FROM athletes ORDER BY id"); err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		a, isAmbiguous := matchAthlete(athletes, entry)
+
+		if a.ID != 0 {
+			// fill in what we didn't know about the athlete
+			_, err = db.Exec(`UPDATE athletes SET
+				email=CASE WHEN email='' THEN $1 ELSE email END,
+				dob=CASE WHEN dob='' THEN $2 ELSE dob END
+				WHERE id=$3`, entry.Email, entry.DOB, a.ID)
+			if err != nil {
+				return
+			}
+			linked++
+		} else {
+			a = Athlete{Name: strings.TrimSpace(entry.BoatName), Email: entry.Email, DOB: entry.DOB}
+			if err = a.Insert(db); err != nil {
+				return
+			}
+			athletes = append(athletes, a)
+			created++
+			if isAmbiguous {
+				ambiguous++
+			}
+		}
+
+		if _, err = db.Exec("UPDATE entries SET athlete_id=$1 WHERE id=$2", a.ID, entry.ID); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// PossibleDuplicates returns the pairs of athletes that might be the same
+// person: they have the same name, and their dates of birth don't rule it
+// out.  Pairs marked as distinct are left out.
+func PossibleDuplicates(db *sqlx.DB) (pairs []AthletePair, err error) {
+	sql := `
+SELECT
+	a.id "a.id", a.name "a.name", a.email "a.email", a.dob "a.dob", a.created_at "a.created_at",
+	b.id "b.id", b.name "b.name", b.email "b.email", b.dob "b.dob", b.created_at "b.created_at"
+FROM
+	athletes a JOIN athletes b ON a.id < b.id
+WHERE
+	lower(regexp_replace(trim(a.name), '\s+', ' ', 'g')) = lower(regexp_replace(trim(b.name), '\s+', ' ', 'g'))
+	AND NOT (a.dob <> '' AND b.dob <> '' AND a.dob <> b.dob)
+	AND NOT EXISTS (SELECT 1 FROM distinctathletes d WHERE d.athlete_id = a.id AND d.other_id = b.id)
+ORDER BY
+	a.name, a.id, b.id`
+
+	err = db.Select(&pairs, sql)
+	return
+}
+
+// MergeAthletes moves every entry of the duplicate to the athlete, and
+// deletes the duplicate
+func MergeAthletes(db *sqlx.DB, athleteID int, duplicateID int) error {
+	if athleteID == duplicateID {
+		return fmt.Errorf("cannot merge athlete %d with itself", athleteID)
+	}
+
+	keep, err := LoadAthlete(db, athleteID)
+	if err != nil {
+		return err
+	}
+	dup, err := LoadAthlete(db, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	if keep.DOB != "" && dup.DOB != "" && keep.DOB != dup.DOB {
+		return fmt.Errorf("athletes %d and %d have different dates of birth", keep.ID, dup.ID)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE entries SET athlete_id=$1 WHERE athlete_id=$2", keep.ID, dup.ID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE athletes SET
+		email=CASE WHEN email='' THEN $1 ELSE email END,
+		dob=CASE WHEN dob='' THEN $2 ELSE dob END
+		WHERE id=$3`, dup.Email, dup.DOB, keep.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM distinctathletes WHERE athlete_id=$1 OR other_id=$1", dup.ID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM athletes WHERE id=$1", dup.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkDistinct records that two athletes are different people, so they
+// are no longer shown as possible duplicates
+func MarkDistinct(db *sqlx.DB, athleteID int, otherID int) error {
+	if athleteID > otherID {
+		athleteID, otherID = otherID, athleteID
+	}
+
+	_, err := db.Exec(`INSERT INTO DistinctAthletes(athlete_id, other_id) VALUES($1, $2)
+		ON CONFLICT DO NOTHING`, athleteID, otherID)
+	return err
+}
+
+// AthleteConflict is an athlete scheduled in two races that are too close together
+type AthleteConflict struct {
+	Athlete Athlete
+	First   Entry
+	Second  Entry
+	Gap     time.Duration // between the start times of the races
+}
+
+// ScheduleConflicts returns the athletes in the regatta who are scheduled in
+// two races that start less than minGap apart
+func ScheduleConflicts(db *sqlx.DB, minGap time.Duration) ([]AthleteConflict, error) {
+	var rows []struct {
+		Entry
+		StartTime time.Time `db:"start_time"`
+	}
+
+	err := db.Select(&rows, `SELECT entries.*, races.start_time FROM entries
+		JOIN races ON entries.race_id = races.id
+		WHERE entries.regatta_id=$1 AND entries.athlete_id <> 0 AND entries.scratched IS NOT true
+		ORDER BY entries.athlete_id, races.start_time`, RegattaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []AthleteConflict
+	for i := 1; i < len(rows); i++ {
+		prev, cur := rows[i-1], rows[i]
+		if prev.AthleteID != cur.AthleteID {
+			continue
+		}
+
+		gap := cur.StartTime.Sub(prev.StartTime)
+		if gap >= minGap {
+			continue
+		}
+
+		a, err := LoadAthlete(db, cur.AthleteID)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, AthleteConflict{Athlete: a, First: prev.Entry, Second: cur.Entry, Gap: gap})
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"time"
+)
+
+// CertificationSchema is the sql commands to create the Certifications table
+var CertificationSchema = []string{
+	`CREATE TABLE Certifications (
+		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
+		event_id INTEGER DEFAULT 0,
+		certified BOOLEAN DEFAULT false,
+		at TIMESTAMPTZ DEFAULT now(),
+		operator TEXT DEFAULT ''::text
+	);`,
+	"CREATE INDEX ON Certifications (regatta_id, event_id);",
+}
+
+// latestCertifications selects the most recent certification of each event
+// in the regatta given by $1
+const latestCertifications = `SELECT DISTINCT ON (event_id) * FROM certifications
+	WHERE regatta_id=$1
+	ORDER BY event_id, at DESC, id DESC`
+
+// Certification records an event's results being certified as official,
+// or uncertified.  Rows are never changed, so the table is an audit trail.
+type Certification struct {
+	ID        int       `db:"id"`
+	RegattaID int       `db:"regatta_id"`
+	EventID   int       `db:"event_id"`
+	Certified bool      `db:"certified"`
+	At        time.Time `db:"at"`
+	Operator  string    `db:"operator"`
+}
+
+// Insert will add the certification to the audit trail.  If the Operator
+// isn't set, it is the operator of the connection or transaction, like the
+// change log's.
+func (c Certification) Insert(db DB) error {
+	sql := `INSERT INTO Certifications(regatta_id, event_id, certified, operator)
+		VALUES(:regatta_id, :event_id, :certified,
+			COALESCE(NULLIF(:operator, ''), NULLIF(current_setting('race.operator', true), ''), session_user));`
+
+	c.RegattaID = RegattaID
+
+	_, err := db.NamedExec(sql, &c)
+	return err
+}
+
+// LoadCertifications returns the current certification of every event that
+// has ever been certified, keyed by event id
+func LoadCertifications(db DB) (map[int]Certification, error) {
+	var certs []Certification
+	if err := db.Select(&certs, latestCertifications, RegattaID); err != nil {
+		return nil, err
+	}
+
+	byEvent := make(map[int]Certification, len(certs))
+	for _, c := range certs {
+		byEvent[c.EventID] = c
+	}
+	return byEvent, nil
+}
+
+// CertifiedBibs returns the bib numbers of every entry in a certified event.
+// Their results are locked, and cannot be changed.
+func CertifiedBibs(db DB) (map[int]bool, error) {
+	var bibs []int
+	err := db.Select(&bibs, `SELECT bib_num FROM entries WHERE regatta_id=$1 AND event_id IN
+		(SELECT event_id FROM (`+latestCertifications+`) c WHERE certified)`, RegattaID)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[int]bool, len(bibs))
+	for _, bib := range bibs {
+		locked[bib] = true
+	}
+	return locked, nil
+}
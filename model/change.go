@@ -0,0 +1,127 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ChangeSchema is the sql commands to create the Changes table, and the
+// triggers that record every change to the Entries, Results and Races tables.
+// It must be run after those tables are created.
+//
+// Who made the change, and with which command, are read from the race.operator
+// and race.command settings of the connection, or of the transaction if it
+// sets them (see SetOperator).
+var ChangeSchema = []string{
+	`CREATE TABLE Changes (
+		id BIGSERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
+		changed_at TIMESTAMPTZ DEFAULT now(),
+		operator TEXT DEFAULT ''::text,
+		command TEXT DEFAULT ''::text,
+		table_name TEXT DEFAULT ''::text,
+		action TEXT DEFAULT ''::text,
+		before JSONB,
+		after JSONB
+	);`,
+	"CREATE INDEX ON Changes (regatta_id, changed_at);",
+	`CREATE FUNCTION log_change() RETURNS trigger AS $$
+	DECLARE
+		before_row JSONB;
+		after_row JSONB;
+	BEGIN
+		IF TG_OP <> 'INSERT' THEN
+			before_row := to_jsonb(OLD);
+		END IF;
+		IF TG_OP <> 'DELETE' THEN
+			after_row := to_jsonb(NEW);
+		END IF;
+		IF before_row = after_row THEN
+			RETURN NULL;
+		END IF;
+
+		INSERT INTO Changes(regatta_id, operator, command, table_name, action, before, after)
+		VALUES(
+			(COALESCE(after_row, before_row)->>'regatta_id')::int,
+			COALESCE(NULLIF(current_setting('race.operator', true), ''), session_user),
+			COALESCE(current_setting('race.command', true), ''),
+			TG_TABLE_NAME, TG_OP, before_row, after_row);
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;`,
+	`CREATE TRIGGER entries_changes AFTER INSERT OR UPDATE OR DELETE ON Entries
+		FOR EACH ROW EXECUTE PROCEDURE log_change();`,
+	`CREATE TRIGGER results_changes AFTER INSERT OR UPDATE OR DELETE ON Results
+		FOR EACH ROW EXECUTE PROCEDURE log_change();`,
+	`CREATE TRIGGER races_changes AFTER INSERT OR UPDATE OR DELETE ON Races
+		FOR EACH ROW EXECUTE PROCEDURE log_change();`,
+}
+
+// SetOperator records who is making the changes in the transaction, in place
+// of the connection's operator, until the transaction ends.  The API server
+// uses it so each change is logged as made by whoever requested it.
+func SetOperator(tx *sqlx.Tx, operator string) error {
+	_, err := tx.Exec("SELECT set_config('race.operator', $1, true)", operator)
+	return err
+}
+
+// Change is one row of an entry, result or race that was inserted, updated or deleted
+type Change struct {
+	ID        int64     `db:"id"`
+	RegattaID int       `db:"regatta_id"`
+	ChangedAt time.Time `db:"changed_at"`
+	Operator  string    `db:"operator"`
+	Command   string    `db:"command"`    // like "race move"
+	TableName string    `db:"table_name"` // "entries", "results" or "races"
+	Action    string    `db:"action"`     // "INSERT", "UPDATE" or "DELETE"
+	Before    []byte    `db:"before"`     // the row as JSON, nil for an INSERT
+	After     []byte    `db:"after"`      // the row as JSON, nil for a DELETE
+}
+
+// ChangeFilter selects changes from the log, zero fields match everything
+type ChangeFilter struct {
+	Table    string
+	BibNum   int
+	RaceID   int // the race, and the entries moved into or out of it
+	Operator string
+	Since    time.Time
+	Limit    int
+}
+
+// LoadChanges returns the changes that match the filter, newest first
+func LoadChanges(db *sqlx.DB, filter ChangeFilter) (changes []Change, err error) {
+	where := []string{"regatta_id=$1"}
+	args := []interface{}{RegattaID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "$?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+
+	if filter.Table != "" {
+		add("table_name=$?", strings.ToLower(filter.Table))
+	}
+	if filter.BibNum != 0 {
+		add("table_name IN ('entries', 'results') AND (COALESCE(after, before)->>'bib_num')::int=$?", filter.BibNum)
+	}
+	if filter.RaceID != 0 {
+		add(`((table_name='races' AND (COALESCE(after, before)->>'id')::int=$?) OR
+			(table_name IN ('entries', 'results') AND $? IN ((before->>'race_id')::int, (after->>'race_id')::int)))`, filter.RaceID)
+	}
+	if filter.Operator != "" {
+		add("operator=$?", filter.Operator)
+	}
+	if !filter.Since.IsZero() {
+		add("changed_at>=$?", filter.Since)
+	}
+
+	sql := "SELECT * FROM changes WHERE " + strings.Join(where, " AND ") + " ORDER BY changed_at DESC, id DESC"
+	if filter.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	err = db.Select(&changes, sql, args...)
+	return
+}
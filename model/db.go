@@ -0,0 +1,40 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DB is a database, or a transaction on one.  Functions that are part of a
+// larger change take a DB, so the change can be made in one transaction.
+type DB interface {
+	sqlx.Ext
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	NamedExec(query string, arg interface{}) (sql.Result, error)
+}
+
+// Transact runs fn in a transaction on the db, and commits it if fn succeeds.
+// If db is already a transaction fn is run in it, and it is left to the
+// caller to commit.
+func Transact(db DB, fn func(tx *sqlx.Tx) error) error {
+	switch db := db.(type) {
+	case *sqlx.Tx:
+		return fn(db)
+	case *sqlx.DB:
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	default:
+		return fmt.Errorf("can't start a transaction on a %T", db)
+	}
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cjrc/race/internal/testdb"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDB creates the regatta's tables in a schema of its own in the Postgres
+// database in RACE_TEST_DB.  Tests that need a database are skipped when
+// RACE_TEST_DB isn't set.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Connect("postgres", testdb.DSN(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var schemas []string
+	schemas = append(schemas, RegattaSchema...)
+	schemas = append(schemas, AthleteSchema...)
+	schemas = append(schemas, EntrySchema...)
+	schemas = append(schemas, ResultSchema...)
+	schemas = append(schemas, RaceSchema...)
+	schemas = append(schemas, ChangeSchema...)
+	schemas = append(schemas, CertificationSchema...)
+	for _, s := range schemas {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	RegattaID = 1
+	return db
+}
+
+// insertTestRace adds a race with the number of lanes, and returns its id
+func insertTestRace(t *testing.T, db *sqlx.DB, name string, nlanes int) int {
+	t.Helper()
+
+	var id int
+	err := db.Get(&id, "INSERT INTO races (regatta_id, name, nlanes) VALUES ($1, $2, $3) RETURNING id",
+		RegattaID, name, nlanes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// insertTestEntry adds an entry with the bib number, and returns it
+func insertTestEntry(t *testing.T, db *sqlx.DB, bibNum int) Entry {
+	t.Helper()
+
+	entry := Entry{BoatName: fmt.Sprintf("Boat %d", bibNum), BibNum: bibNum}
+	if _, err := entry.Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := LoadEntryByBib(db, bibNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
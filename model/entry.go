@@ -1,6 +1,8 @@
 package model
 
 import (
+	"database/sql"
+	"fmt"
 	"sort"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 var EntrySchema = []string{
 	`CREATE TABLE Entries (
 			id SERIAL PRIMARY KEY,
+			regatta_id INTEGER DEFAULT 0,
 			email TEXT DEFAULT '',
 			club_name TEXT DEFAULT '',
 			club_abbrev TEXT DEFAULT '',
@@ -23,11 +26,15 @@ var EntrySchema = []string{
 			lane INTEGER DEFAULT 0,
 			scratched BOOLEAN DEFAULT false,
 			ltwt BOOLEAN DEFAULT false,
-			bib_num INTEGER UNIQUE
+			bib_num INTEGER,
+			athlete_id INTEGER DEFAULT 0,
+			dob TEXT DEFAULT '',
+			UNIQUE (regatta_id, bib_num)
 		);`,
 	"CREATE INDEX ON Entries (race_id);",
 	"CREATE INDEX ON Entries (event_id);",
-	"CREATE INDEX ON Entries (bib_num);",
+	"CREATE INDEX ON Entries (regatta_id, bib_num);",
+	"CREATE INDEX ON Entries (athlete_id);",
 	// `CREATE OR REPLACE FUNCTION notify_entries() RETURNS TRIGGER AS $$
 	//  BEGIN
 	//    NOTIFY entries;
@@ -42,6 +49,7 @@ var EntrySchema = []string{
 // Entry represents one boat in the regatta
 type Entry struct {
 	ID         int           `db:"id"`
+	RegattaID  int           `db:"regatta_id"`
 	Email      string        `db:"email"`
 	ClubName   string        `db:"club_name"`
 	ClubAbbrev string        `db:"club_abbrev"`
@@ -55,6 +63,8 @@ type Entry struct {
 	Scratched  bool          `db:"scratched"`
 	Ltwt       bool          `db:"ltwt"`
 	BibNum     int           `db:"bib_num"`
+	AthleteID  int           `db:"athlete_id"` // 0 until linked by LinkAthletes
+	DOB        string        `db:"dob"`        // YYYY-MM-DD, if the entries file has it
 	Result     Result        `db:"result"`
 }
 
@@ -62,13 +72,15 @@ type Entry struct {
 // Ignores conflict if bibnum already exists
 // Returns true if entry was inserted
 func (entry Entry) Insert(db *sqlx.DB) (bool, error) {
-	sql := `INSERT INTO Entries(email, club_name, club_abbrev, seed, age, boat_name, 
-		country, event_id, bib_num)
-		VALUES(:email, :club_name, :club_abbrev, :seed, :age, :boat_name,
-		:country, :event_id, :bib_num)
-		ON CONFLICT (bib_num)
+	sql := `INSERT INTO Entries(regatta_id, email, club_name, club_abbrev, seed, age, boat_name, 
+		country, event_id, bib_num, dob)
+		VALUES(:regatta_id, :email, :club_name, :club_abbrev, :seed, :age, :boat_name,
+		:country, :event_id, :bib_num, :dob)
+		ON CONFLICT (regatta_id, bib_num)
 		DO NOTHING;`
 
+	entry.RegattaID = RegattaID
+
 	res, err := db.NamedExec(sql, &entry)
 	if err != nil {
 		return false, err
@@ -78,29 +90,132 @@ func (entry Entry) Insert(db *sqlx.DB) (bool, error) {
 	return (num == 1), nil
 }
 
+// LoadEntryByBib returns the entry with the specified bib number
+func LoadEntryByBib(db DB, bibNum int) (entry Entry, err error) {
+	err = db.Get(&entry, "SELECT * FROM entries WHERE regatta_id=$1 AND bib_num=$2", RegattaID, bibNum)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("there is no entry with bib number %d", bibNum)
+	}
+	return
+}
+
+// Scratch marks the entry as scratched, or not scratched.  A scratched entry
+// keeps its race and lane, so it can be reinstated.
+func (entry *Entry) Scratch(db DB, scratched bool) error {
+	if _, err := db.Exec("UPDATE entries SET scratched=$1 WHERE id=$2", scratched, entry.ID); err != nil {
+		return err
+	}
+	entry.Scratched = scratched
+	return nil
+}
+
+// MoveToLane assigns the entry to a lane in the specified race.  It is an error
+// to move into a lane that doesn't exist, or that another entry is racing in.
+// The race is locked while the lane is checked, so two moves can't both take
+// the same lane.
+func (entry *Entry) MoveToLane(db DB, raceID int, lane int) error {
+	err := Transact(db, func(tx *sqlx.Tx) error {
+		var nlanes int
+		err := tx.Get(&nlanes, "SELECT nlanes FROM races WHERE regatta_id=$1 AND id=$2 FOR UPDATE", RegattaID, raceID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("there is no race %d", raceID)
+		} else if err != nil {
+			return err
+		}
+
+		if lane < 1 || (nlanes > 0 && lane > nlanes) {
+			return fmt.Errorf("race %d has no lane %d", raceID, lane)
+		}
+
+		var other []int
+		err = tx.Select(&other, `SELECT bib_num FROM entries
+			WHERE regatta_id=$1 AND race_id=$2 AND lane=$3 AND id<>$4 AND scratched IS NOT true`,
+			RegattaID, raceID, lane, entry.ID)
+		if err != nil {
+			return err
+		}
+		if len(other) > 0 {
+			return fmt.Errorf("lane %d of race %d is taken by bib %d", lane, raceID, other[0])
+		}
+
+		_, err = tx.Exec("UPDATE entries SET race_id=$1, lane=$2 WHERE id=$3", raceID, lane, entry.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	entry.RaceID = raceID
+	entry.Lane = lane
+	return nil
+}
+
+// LoadEntries returns every entry in the regatta, ordered by bib number
+func LoadEntries(db *sqlx.DB) (entries []Entry, err error) {
+	err = db.Select(&entries, "SELECT * FROM entries WHERE regatta_id=$1 ORDER BY bib_num", RegattaID)
+	return
+}
+
+// LoadScheduledEntries returns every entry that has been assigned to a race,
+// ordered by race and lane.  Scratched entries are not included.
+func LoadScheduledEntries(db *sqlx.DB) (entries []Entry, err error) {
+	sql := `SELECT * FROM entries
+		WHERE regatta_id=$1 AND race_id <> 0 AND scratched IS NOT true
+		ORDER BY race_id, lane`
+
+	err = db.Select(&entries, sql, RegattaID)
+	return
+}
+
+// NotifyEntries will send the 'entries' notification to the DB
+func NotifyEntries(db DB) error {
+	_, err := db.Exec("NOTIFY entries;")
+	return err
+}
+
+// placed reports if the entry finished and should be given a place
+func (entry Entry) placed() bool {
+	return entry.Result.Time != 0 && !entry.Result.DQ
+}
+
+// sortRank orders the entries that finished, then the disqualified entries,
+// then the entries that did not race
+func (entry Entry) sortRank() int {
+	switch {
+	case entry.placed():
+		return 0
+	case entry.Result.DQ:
+		return 1
+	default:
+		return 2
+	}
+}
+
 // SortEntriesByTime sorts the slice of entries, with the fastest finishing times coming first
 // A Finish time of 0 indicates that the entry did not race, and they will be sorted to the
-// end
+// end, after any disqualified entries
 func SortEntriesByTime(entries []Entry) {
-	// Sort finishing times
-	// A finishing time of 0 means they didn't start
-	sort.Slice(entries, func(h, k int) bool {
-		if entries[h].Result.Time == 0 {
-			return false
-		} else if entries[k].Result.Time == 0 {
-			return true
+	sort.SliceStable(entries, func(h, k int) bool {
+		rh, rk := entries[h].sortRank(), entries[k].sortRank()
+		if rh != rk {
+			return rh < rk
 		}
-		return entries[h].Result.Time < entries[k].Result.Time
+		return rh == 0 && entries[h].Result.Time < entries[k].Result.Time
 	})
 }
 
 // AssignPlacesToEntries will sort the entries by their finishing times,
-// and assign places, taking ties into account
+// and assign places, taking ties into account.  Disqualified entries
+// are not placed.
 func AssignPlacesToEntries(entries []Entry) {
 	SortEntriesByTime(entries)
 
 	place := 1
 	for j := range entries {
+		if !entries[j].placed() {
+			entries[j].Result.Place = 0
+			continue
+		}
 		if j == 0 {
 			entries[j].Result.Place = place
 			// deal with ties appropriately
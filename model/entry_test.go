@@ -0,0 +1,174 @@
+package model
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// finisher is an entry with a result, for the sorting tests
+func finisher(bibNum int, finishTime time.Duration, dq bool) Entry {
+	return Entry{BibNum: bibNum, Result: Result{BibNum: bibNum, Time: finishTime, DQ: dq}}
+}
+
+func bibs(entries []Entry) []int {
+	var nums []int
+	for _, e := range entries {
+		nums = append(nums, e.BibNum)
+	}
+	return nums
+}
+
+func TestSortEntriesByTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    []int
+	}{
+		{"empty", nil, nil},
+		{"by time", []Entry{
+			finisher(1, 7*time.Minute, false),
+			finisher(2, 6*time.Minute, false),
+			finisher(3, 8*time.Minute, false),
+		}, []int{2, 1, 3}},
+		{"no time last", []Entry{
+			finisher(1, 0, false),
+			finisher(2, 7*time.Minute, false),
+		}, []int{2, 1}},
+		{"dq after the placed", []Entry{
+			finisher(1, 6*time.Minute, true),
+			finisher(2, 7*time.Minute, false),
+		}, []int{2, 1}},
+		{"dq before no time", []Entry{
+			finisher(1, 0, false),
+			finisher(2, 6*time.Minute, true),
+			finisher(3, 0, true),
+		}, []int{2, 3, 1}},
+		{"mixed", []Entry{
+			finisher(1, 0, false),
+			finisher(2, 6*time.Minute, true),
+			finisher(3, 7*time.Minute, false),
+			finisher(4, 0, false),
+			finisher(5, 5*time.Minute, false),
+			finisher(6, 8*time.Minute, true),
+			finisher(7, 6*time.Minute, false),
+		}, []int{5, 7, 3, 2, 6, 1, 4}},
+	}
+
+	for _, tt := range tests {
+		SortEntriesByTime(tt.entries)
+		if got := bibs(tt.entries); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got bibs %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAssignPlacesToEntries(t *testing.T) {
+	entries := []Entry{
+		finisher(1, 7*time.Minute, false),
+		finisher(2, 0, false),
+		finisher(3, 6*time.Minute, false),
+		finisher(4, 5*time.Minute, true),
+		finisher(5, 7*time.Minute, false),
+		finisher(6, 8*time.Minute, false),
+	}
+	want := map[int]int{3: 1, 1: 2, 5: 2, 6: 4, 4: 0, 2: 0}
+
+	AssignPlacesToEntries(entries)
+	for _, e := range entries {
+		if e.Result.Place != want[e.BibNum] {
+			t.Errorf("bib %d: got place %d, want %d", e.BibNum, e.Result.Place, want[e.BibNum])
+		}
+	}
+}
+
+func TestMoveToLane(t *testing.T) {
+	db := testDB(t)
+
+	raceID := insertTestRace(t, db, "Race 1", 4)
+	first := insertTestEntry(t, db, 101)
+	second := insertTestEntry(t, db, 102)
+	scratched := insertTestEntry(t, db, 103)
+
+	if err := first.MoveToLane(db, raceID, 2); err != nil {
+		t.Fatalf("moving bib 101 into an empty lane: %v", err)
+	}
+	if first.RaceID != raceID || first.Lane != 2 {
+		t.Errorf("bib 101 is in race %d lane %d, want race %d lane 2", first.RaceID, first.Lane, raceID)
+	}
+	if err := scratched.MoveToLane(db, raceID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := scratched.Scratch(db, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		raceID int
+		lane   int
+		ok     bool
+	}{
+		{"taken lane", raceID, 2, false},
+		{"lane 0", raceID, 0, false},
+		{"past the last lane", raceID, 5, false},
+		{"no such race", raceID + 100, 1, false},
+		{"scratched entry's lane", raceID, 3, true},
+		{"last lane", raceID, 4, true},
+	}
+	for _, tt := range tests {
+		err := second.MoveToLane(db, tt.raceID, tt.lane)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: moved into race %d lane %d", tt.name, tt.raceID, tt.lane)
+		}
+	}
+
+	// the entry can be moved within its own lane
+	if err := first.MoveToLane(db, raceID, 2); err != nil {
+		t.Errorf("moving bib 101 into its own lane: %v", err)
+	}
+}
+
+func TestMoveToLaneConcurrent(t *testing.T) {
+	db := testDB(t)
+
+	raceID := insertTestRace(t, db, "Race 1", 8)
+	var entries []Entry
+	for bib := 201; bib <= 208; bib++ {
+		entries = append(entries, insertTestEntry(t, db, bib))
+	}
+
+	// every entry tries to take lane 1 at once, only one of them may get it
+	var wg sync.WaitGroup
+	errs := make([]error, len(entries))
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = entries[i].MoveToLane(db, raceID, 1)
+		}(i)
+	}
+	wg.Wait()
+
+	moved := 0
+	for _, err := range errs {
+		if err == nil {
+			moved++
+		}
+	}
+	if moved != 1 {
+		t.Errorf("%d entries were moved into lane 1, want 1", moved)
+	}
+
+	var inLane int
+	if err := db.Get(&inLane, "SELECT count(*) FROM entries WHERE race_id=$1 AND lane=1", raceID); err != nil {
+		t.Fatal(err)
+	}
+	if inLane != 1 {
+		t.Errorf("%d entries are in lane 1, want 1", inLane)
+	}
+}
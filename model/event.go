@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/jmoiron/sqlx"
 )
 
@@ -16,6 +18,17 @@ type Event struct {
 	// When scheduling races, events in the same group will be scheduled together
 	// scheduling command sorts by group number
 	Group int
+
+	// Record is the fastest time ever rowed in this event, 0 if there isn't one
+	Record time.Duration `yaml:"record,omitempty"`
+
+	// Official is true once the event's results have been certified, at OfficialAt.
+	// They are loaded from the database, not the config.
+	Official   bool      `yaml:"-"`
+	OfficialAt time.Time `yaml:"-"`
+
+	// UnderProtest is true while the event has a protest that isn't closed
+	UnderProtest bool `yaml:"-"`
 }
 
 // LoadEntriesWithResults populates the Entries field for the specified event
@@ -30,13 +43,62 @@ SELECT
 	results.name "result.name",
 	results.bib_num "result.bib_num",
 	results.class "result.class",
-	results.official "result.official"
+	results.lane "result.lane",
+	results.official "result.official",
+	results.dq "result.dq",
+	results.imported_at "result.imported_at",
+	results.source "result.source",
+	results.race_id "result.race_id"
 FROM
-	entries JOIN results ON entries.bib_num = results.bib_num
+	entries ` + entryResult + `
 WHERE
-	event_id=$1`
+	entries.regatta_id=$1 AND event_id=$2`
 
 	event.Entries = nil // so entries cannot be double loaded
-	err = db.Select(&event.Entries, sql, event.ID)
+	err = db.Select(&event.Entries, sql, RegattaID, event.ID)
+	return
+}
+
+// SetOfficial marks every result in the event as official, or unofficial
+func (event *Event) SetOfficial(db DB, official bool) error {
+	sql := `UPDATE results SET official=$1
+		WHERE regatta_id=$2 AND superseded_at IS NULL AND bib_num IN (SELECT bib_num FROM entries WHERE regatta_id=$2 AND event_id=$3)`
+
+	if _, err := db.Exec(sql, official, RegattaID, event.ID); err != nil {
+		return err
+	}
+	event.Official = official
+	return nil
+}
+
+// Lock locks the event until the transaction ends, so it can't be certified
+// while a protest is being filed against it, or the other way round
+func (event Event) Lock(tx *sqlx.Tx) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", RegattaID, event.ID)
+	return err
+}
+
+// FirstCompleted returns when the last of the event's results was first
+// imported, so corrections don't count.  It is the zero time if the event
+// has no results.
+func (event Event) FirstCompleted(db DB) (time.Time, error) {
+	var completed *time.Time
+	err := db.Get(&completed, `SELECT max(results.imported_at)
+		FROM results JOIN entries ON entries.regatta_id = results.regatta_id AND entries.bib_num = results.bib_num
+		WHERE results.regatta_id=$1 AND entries.event_id=$2 AND results.version=1`, RegattaID, event.ID)
+	if err != nil || completed == nil {
+		return time.Time{}, err
+	}
+	return *completed, nil
+}
+
+// Completed returns when the last result of the event was imported.  It is the
+// zero time if the event has no results.
+func (event Event) Completed() (completed time.Time) {
+	for _, entry := range event.Entries {
+		if entry.Result.ImportedAt.After(completed) {
+			completed = entry.Result.ImportedAt
+		}
+	}
 	return
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ImportSchema is the sql commands to create the Imports table
+var ImportSchema = []string{
+	`CREATE TABLE Imports (
+		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
+		imported_at TIMESTAMPTZ DEFAULT now(),
+		kind TEXT DEFAULT ''::text,
+		filename TEXT DEFAULT ''::text,
+		added INTEGER DEFAULT 0,
+		ignored INTEGER DEFAULT 0,
+		error TEXT DEFAULT ''::text,
+		checksum TEXT DEFAULT ''::text
+	);`,
+}
+
+// Import is the log of one file of entries or results that was imported
+type Import struct {
+	ID         int       `db:"id" json:"id"`
+	RegattaID  int       `db:"regatta_id" json:"regatta_id"`
+	ImportedAt time.Time `db:"imported_at" json:"imported_at"`
+	Kind       string    `db:"kind" json:"kind"` // "entries" or "results"
+	Filename   string    `db:"filename" json:"filename"`
+	Added      int       `db:"added" json:"added"`       // rows that were added to the database
+	Ignored    int       `db:"ignored" json:"ignored"`   // duplicate rows that were ignored
+	Error      string    `db:"error" json:"error"`       // empty if the import succeeded
+	Checksum   string    `db:"checksum" json:"checksum"` // sha256 of the file
+}
+
+// Insert will add the import to the log
+func (imp Import) Insert(db *sqlx.DB) error {
+	sql := `INSERT INTO Imports(regatta_id, kind, filename, added, ignored, error, checksum)
+		VALUES(:regatta_id, :kind, :filename, :added, :ignored, :error, :checksum);`
+
+	imp.RegattaID = RegattaID
+
+	_, err := db.NamedExec(sql, &imp)
+	return err
+}
+
+// LoadImports returns the most recent imports, newest first
+func LoadImports(db *sqlx.DB, limit int) (imports []Import, err error) {
+	err = db.Select(&imports, `SELECT * FROM imports WHERE regatta_id=$1
+		ORDER BY imported_at DESC, id DESC LIMIT $2`, RegattaID, limit)
+	return
+}
+
+// ImportedChecksums returns the checksums of every file of the kind that was
+// imported without an error.  It is the ledger that stops a file from being
+// imported twice.
+func ImportedChecksums(db *sqlx.DB, kind string) (map[string]bool, error) {
+	var sums []string
+	err := db.Select(&sums, `SELECT checksum FROM imports
+		WHERE regatta_id=$1 AND kind=$2 AND error='' AND checksum<>''`, RegattaID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := make(map[string]bool, len(sums))
+	for _, sum := range sums {
+		ledger[sum] = true
+	}
+	return ledger, nil
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// NotificationSchema is the sql commands to create the Notifications table
+var NotificationSchema = []string{
+	`CREATE TABLE Notifications (
+		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
+		sent_at TIMESTAMPTZ DEFAULT now(),
+		kind TEXT DEFAULT ''::text,
+		bib_num INTEGER DEFAULT 0,
+		email TEXT DEFAULT ''::text,
+		digest TEXT DEFAULT ''::text
+	);`,
+}
+
+// Notification is the log of one email sent to an athlete
+type Notification struct {
+	ID        int       `db:"id"`
+	RegattaID int       `db:"regatta_id"`
+	SentAt    time.Time `db:"sent_at"`
+	Kind      string    `db:"kind"` // "assignments" or "results"
+	BibNum    int       `db:"bib_num"`
+	Email     string    `db:"email"`
+	Digest    string    `db:"digest"` // sha256 of the address and message, without its date
+}
+
+// Insert will add the notification to the log
+func (n Notification) Insert(db *sqlx.DB) error {
+	sql := `INSERT INTO Notifications(regatta_id, kind, bib_num, email, digest)
+		VALUES(:regatta_id, :kind, :bib_num, :email, :digest);`
+
+	n.RegattaID = RegattaID
+
+	_, err := db.NamedExec(sql, &n)
+	return err
+}
+
+// SentDigests returns the digests of every email of the kind that was sent.
+// An email that hasn't changed since it was sent isn't sent again.
+func SentDigests(db *sqlx.DB, kind string) (map[string]bool, error) {
+	var digests []string
+	err := db.Select(&digests, `SELECT digest FROM notifications
+		WHERE regatta_id=$1 AND kind=$2`, RegattaID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := make(map[string]bool, len(digests))
+	for _, d := range digests {
+		sent[d] = true
+	}
+	return sent, nil
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Protest status
+const (
+	ProtestOpen   = "open"   // filed, waiting for a ruling
+	ProtestRuled  = "ruled"  // the referee has ruled, but it isn't closed
+	ProtestClosed = "closed" // finished, it no longer blocks certification
+)
+
+// ProtestSchema is the sql commands to create the Protests table
+var ProtestSchema = []string{
+	`CREATE TABLE Protests (
+		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
+		event_id INTEGER DEFAULT 0,
+		bib_num INTEGER DEFAULT 0,
+		filed_by TEXT DEFAULT ''::text,
+		reason TEXT DEFAULT ''::text,
+		status TEXT DEFAULT 'open',
+		ruling TEXT DEFAULT ''::text,
+		dq BOOLEAN DEFAULT false,
+		adjustment BIGINT DEFAULT 0,
+		filed_at TIMESTAMPTZ DEFAULT now(),
+		ruled_at TIMESTAMPTZ,
+		closed_at TIMESTAMPTZ
+	);`,
+	"CREATE INDEX ON Protests (regatta_id, event_id);",
+}
+
+// Protest is a protest against the results of an event, or of one bib in it
+type Protest struct {
+	ID         int           `db:"id"`
+	RegattaID  int           `db:"regatta_id"`
+	EventID    int           `db:"event_id"`
+	BibNum     int           `db:"bib_num"` // 0 if the protest is against the whole event
+	FiledBy    string        `db:"filed_by"`
+	Reason     string        `db:"reason"`
+	Status     string        `db:"status"`
+	Ruling     string        `db:"ruling"`
+	DQ         bool          `db:"dq"`         // the ruling disqualified the bib
+	Adjustment time.Duration `db:"adjustment"` // the ruling added this to the bib's time
+	FiledAt    time.Time     `db:"filed_at"`
+	RuledAt    *time.Time    `db:"ruled_at"`
+	ClosedAt   *time.Time    `db:"closed_at"`
+}
+
+// Insert files the protest, and sets its ID
+func (p *Protest) Insert(db DB) error {
+	sql := `INSERT INTO Protests(regatta_id, event_id, bib_num, filed_by, reason)
+		VALUES($1, $2, $3, $4, $5) RETURNING id`
+
+	p.RegattaID = RegattaID
+	return db.Get(&p.ID, sql, p.RegattaID, p.EventID, p.BibNum, p.FiledBy, p.Reason)
+}
+
+// LoadProtest returns the protest with the specified id
+func LoadProtest(db DB, id int) (p Protest, err error) {
+	err = db.Get(&p, "SELECT * FROM protests WHERE regatta_id=$1 AND id=$2", RegattaID, id)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("there is no protest %d", id)
+	}
+	return
+}
+
+// LoadProtests returns all the protests in the order they were filed.
+// Closed protests are only included if all is true.
+func LoadProtests(db *sqlx.DB, all bool) (protests []Protest, err error) {
+	if all {
+		err = db.Select(&protests, "SELECT * FROM protests WHERE regatta_id=$1 ORDER BY filed_at, id", RegattaID)
+	} else {
+		err = db.Select(&protests, `SELECT * FROM protests WHERE regatta_id=$1 AND status<>$2
+			ORDER BY filed_at, id`, RegattaID, ProtestClosed)
+	}
+	return
+}
+
+// EventsUnderProtest returns the ids of every event with a protest that isn't closed
+func EventsUnderProtest(db DB) (map[int]bool, error) {
+	var ids []int
+	if err := db.Select(&ids, "SELECT DISTINCT event_id FROM protests WHERE regatta_id=$1 AND status<>$2",
+		RegattaID, ProtestClosed); err != nil {
+		return nil, err
+	}
+
+	events := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		events[id] = true
+	}
+	return events, nil
+}
+
+// Rule records the referee's ruling on the protest.  It is an error if the
+// protest isn't open, so a protest can only be ruled on once.
+func (p *Protest) Rule(db DB, ruling string, dq bool, adjustment time.Duration) error {
+	res, err := db.Exec(`UPDATE protests SET status=$1, ruling=$2, dq=$3, adjustment=$4, ruled_at=now()
+		WHERE id=$5 AND status=$6`, ProtestRuled, ruling, dq, adjustment, p.ID, ProtestOpen)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("protest #%d has already been ruled on", p.ID)
+	}
+
+	p.Status, p.Ruling, p.DQ, p.Adjustment = ProtestRuled, ruling, dq, adjustment
+	return nil
+}
+
+// Close closes the protest, so it no longer blocks certification
+func (p *Protest) Close(db DB) error {
+	if _, err := db.Exec("UPDATE protests SET status=$1, closed_at=now() WHERE id=$2", ProtestClosed, p.ID); err != nil {
+		return err
+	}
+	p.Status = ProtestClosed
+	return nil
+}
@@ -1,10 +1,13 @@
 package model
 
 import (
+	"database/sql"
 	"fmt"
 	"io"
 	"os"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // FILESIG Signature used by Concept2 race files
@@ -25,6 +28,7 @@ const (
 var RaceSchema = []string{
 	`CREATE TABLE Races (
 		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
 		boat_type INTEGER DEFAULT 0,
 		name TEXT DEFAULT ''::text,
 		distance INTEGER DEFAULT 2000,
@@ -34,26 +38,86 @@ var RaceSchema = []string{
 		nlanes INTEGER DEFAULT 10,
 		duration_type INTEGER DEFAULT 0,
 		bank TEXT DEFAULT ''::text,
+		start_time TIMESTAMPTZ DEFAULT now(),
+		completed_at TIMESTAMPTZ
 	);`,
+	"CREATE INDEX ON Races (regatta_id);",
 }
 
 // Race is a flight of boats racing together, they are written to a Concept-2 .RAC file
 // and imported into the Venue Racing software
 type Race struct {
-	BoatType         uint   // one of the consts above
-	Name             string //16 char limit
-	Distance         uint   // in Meters
-	EnableStrokeData bool
-	SplitDistance    uint   // Split Distance in Meters
-	SplitTime        uint   // Split Time in Seconds
-	Boats            []Boat // len(boats) does not need to equal NLanes
-	NLanes           uint   // Number of lanes in this race
-	DurationType     uint   // 0=distance, 1=time?
+	BoatType         uint   `db:"boat_type"` // one of the consts above
+	Name             string `db:"name"`      //16 char limit
+	Distance         uint   `db:"distance"`  // in Meters
+	EnableStrokeData bool   `db:"enable_stroke_data"`
+	SplitDistance    uint   `db:"split_distance"` // Split Distance in Meters
+	SplitTime        uint   `db:"split_times"`    // Split Time in Seconds
+	Boats            []Boat `db:"-"`              // len(boats) does not need to equal NLanes
+	NLanes           uint   `db:"nlanes"`         // Number of lanes in this race
+	DurationType     uint   `db:"duration_type"`  // 0=distance, 1=time?
 
 	// Not used by the Concept 2 racing
-	ID        int    `db:"id"`
-	Bank      string `db:"bank"`
-	StartTime time.Time
+	ID        int       `db:"id"`
+	RegattaID int       `db:"regatta_id"`
+	Bank      string    `db:"bank"`
+	StartTime time.Time `db:"start_time"`
+
+	// CompletedAt is when results for the race were last imported, nil if it
+	// hasn't finished
+	CompletedAt *time.Time `db:"completed_at"`
+}
+
+// VenueName is the race name as written to the .RAC file, and so in the
+// results file.  The race won't start if the name is longer than 16 characters.
+func (race Race) VenueName() string {
+	if len(race.Name) > 16 {
+		return race.Name[:16]
+	}
+	return race.Name
+}
+
+// CompleteRace records that the race has finished, and its results are in
+func CompleteRace(db *sqlx.DB, id int) error {
+	_, err := db.Exec("UPDATE races SET completed_at=now() WHERE regatta_id=$1 AND id=$2", RegattaID, id)
+	return err
+}
+
+// LoadRaces returns all of the scheduled races, ordered by their start time
+func LoadRaces(db *sqlx.DB) (races []Race, err error) {
+	err = db.Select(&races, "SELECT * FROM races WHERE regatta_id=$1 ORDER BY start_time, bank, id", RegattaID)
+	return
+}
+
+// LoadRace returns the race with the specified id
+func LoadRace(db *sqlx.DB, id int) (race Race, err error) {
+	err = db.Get(&race, "SELECT * FROM races WHERE regatta_id=$1 AND id=$2", RegattaID, id)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("there is no race %d", id)
+	}
+	return
+}
+
+// LoadBoats populates the Boats of the race from the entries scheduled in it.
+// Scratched entries are left out.
+func (race *Race) LoadBoats(db *sqlx.DB) error {
+	var entries []Entry
+	err := db.Select(&entries, `SELECT * FROM entries
+		WHERE regatta_id=$1 AND race_id=$2 AND scratched IS NOT true ORDER BY lane`, RegattaID, race.ID)
+	if err != nil {
+		return err
+	}
+
+	race.Boats = nil // so boats cannot be double loaded
+	for _, e := range entries {
+		race.Boats = append(race.Boats, Boat{
+			Name:    e.BoatName,
+			BibNum:  uint(e.BibNum),
+			Country: e.Country,
+			Lane:    uint(e.Lane),
+		})
+	}
+	return nil
 }
 
 // Given a list of boats from a race, this will return the boat that is
@@ -78,21 +142,6 @@ func findByLane(boats []Boat, lane uint) Boat {
 // with team boats
 // see https://c2usa.fogbugz.com/?W44
 func (race Race) Write(w io.Writer) error {
-	// The race won't start if the name is longer than 16 characters
-	maxNameLen := 16
-	if len(race.Name) < maxNameLen {
-		maxNameLen = len(race.Name)
-	}
-
-	// file type signature
-	if _, err := fmt.Fprintln(w, FILESIG); err != nil {
-		return err
-	}
-
-	// file format ver 107 including class.
-	if _, err := fmt.Fprintln(w, FILEVER); err != nil {
-		return err
-	}
 	// file type signature
 	// file format ver 107 including class.
 	// team config (singles=0, doubles=1, fours=2, eights=3)
@@ -101,7 +150,7 @@ func (race Race) Write(w io.Writer) error {
 	// Duration Type
 	// Next line is always 0 (was View Mode in older days)
 	if _, err := fmt.Fprintf(w, "%s\n%s\n%d\n%s\n%d\n%d\n0\n",
-		FILESIG, FILEVER, race.BoatType, race.Name[:maxNameLen],
+		FILESIG, FILEVER, race.BoatType, race.VenueName(),
 		race.Distance, race.DurationType); err != nil {
 		return err
 	}
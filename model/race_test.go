@@ -0,0 +1,57 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRaceWrite(t *testing.T) {
+	race := Race{
+		Name:          "Men's Open 1x Heat 1",
+		Distance:      2000,
+		SplitDistance: 500,
+		SplitTime:     120,
+		NLanes:        3,
+		Boats: []Boat{
+			{Name: "Smith", BibNum: 101, Country: "USA", Lane: 1},
+			{Name: "Jones", BibNum: 102, Country: "CAN", Lane: 3},
+		},
+	}
+
+	want := `RACE
+107
+0
+Men's Open 1x He
+2000
+0
+0
+0
+500
+120
+3
+Smith
+101
+
+USA
+
+ 
+0
+
+
+
+Jones
+102
+
+CAN
+
+0
+`
+
+	var buf bytes.Buffer
+	if err := race.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
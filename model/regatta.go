@@ -0,0 +1,121 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RegattaSchema is the sql commands to create the Regattas table
+var RegattaSchema = []string{
+	`CREATE TABLE Regattas (
+		id SERIAL PRIMARY KEY,
+		name TEXT DEFAULT ''::text,
+		date TEXT DEFAULT ''::text,
+		venue TEXT DEFAULT ''::text,
+		created_at TIMESTAMPTZ DEFAULT now()
+	);`,
+}
+
+// RegattaID is the regatta that entries, results, races and everything else
+// are read from and written to.  It is set from the race.yaml config.
+var RegattaID int
+
+// Regatta is one running of a regatta.  A database can hold many of them,
+// so past years are kept for seeding and records.
+type Regatta struct {
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	Date      string    `db:"date"`
+	Venue     string    `db:"venue"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Insert adds the regatta to the database, and sets its ID
+func (r *Regatta) Insert(db *sqlx.DB) error {
+	sql := `INSERT INTO Regattas(name, date, venue)
+		VALUES($1, $2, $3) RETURNING id`
+
+	return db.Get(&r.ID, sql, r.Name, r.Date, r.Venue)
+}
+
+// LoadRegattas returns every regatta in the database, oldest first
+func LoadRegattas(db *sqlx.DB) (regattas []Regatta, err error) {
+	err = db.Select(&regattas, "SELECT * FROM regattas ORDER BY created_at, id")
+	return
+}
+
+// HistoryResult is one result of an athlete in any regatta
+type HistoryResult struct {
+	RegattaID   int           `db:"regatta_id"`
+	AthleteID   int           `db:"athlete_id"`
+	RegattaName string        `db:"regatta_name"`
+	RegattaDate string        `db:"regatta_date"`
+	BibNum      int           `db:"bib_num"`
+	BoatName    string        `db:"boat_name"`
+	ClubAbbrev  string        `db:"club_abbrev"`
+	EventID     int           `db:"event_id"`
+	Time        time.Duration `db:"time"`
+	Distance    int           `db:"distance"`
+	DQ          bool          `db:"dq"`
+}
+
+// historySelect selects HistoryResults, it needs a WHERE clause
+const historySelect = `
+SELECT
+	regattas.id regatta_id,
+	entries.athlete_id,
+	regattas.name regatta_name,
+	regattas.date regatta_date,
+	entries.bib_num,
+	entries.boat_name,
+	entries.club_abbrev,
+	entries.event_id,
+	results.time,
+	results.distance,
+	results.dq
+FROM
+	entries
+	` + entryResult + `
+	JOIN regattas ON entries.regatta_id = regattas.id`
+
+// historyOrder sorts HistoryResults by regatta
+const historyOrder = `
+ORDER BY
+	regattas.created_at, regattas.id, entries.boat_name, entries.event_id`
+
+// LoadHistory returns the results, across every regatta, of the entries
+// whose boat name contains name
+func LoadHistory(db *sqlx.DB, name string) (history []HistoryResult, err error) {
+	sql := historySelect + `
+WHERE
+	entries.boat_name ILIKE '%' || $1 || '%'` + historyOrder
+
+	err = db.Select(&history, sql, name)
+	return
+}
+
+// LoadAthleteHistory returns the results of one athlete across every regatta
+func LoadAthleteHistory(db *sqlx.DB, athleteID int) (history []HistoryResult, err error) {
+	sql := historySelect + `
+WHERE
+	entries.athlete_id=$1` + historyOrder
+
+	err = db.Select(&history, sql, athleteID)
+	return
+}
+
+// PersonalBests returns the fastest time at each distance in the history.
+// Disqualified results, and non-starters, don't count.
+func PersonalBests(history []HistoryResult) map[int]time.Duration {
+	bests := make(map[int]time.Duration)
+	for _, h := range history {
+		if h.DQ || h.Time == 0 || h.Time == 9999*time.Hour {
+			continue
+		}
+		if best, ok := bests[h.Distance]; !ok || h.Time < best {
+			bests[h.Distance] = h.Time
+		}
+	}
+	return bests
+}
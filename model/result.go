@@ -2,6 +2,7 @@ package model
 
 import (
 	"bufio"
+	"database/sql"
 	"fmt"
 	"io"
 	"os"
@@ -16,15 +17,27 @@ import (
 var ResultSchema = []string{
 	`CREATE TABLE Results (
 		id SERIAL PRIMARY KEY,
+		regatta_id INTEGER DEFAULT 0,
 		place INTEGER DEFAULT 0,
 		time BIGINT DEFAULT 0,
 		avg_pace BIGINT DEFAULT 0,
 		distance INTEGER DEFAULT 0,
 		name text DEFAULT ''::text,
-		bib_num INTEGER UNIQUE,
+		bib_num INTEGER,
 		class VARCHAR(20) DEFAULT ''::text,
 		official BOOLEAN DEFAULT false,
+		dq BOOLEAN DEFAULT false,
+		imported_at TIMESTAMPTZ DEFAULT now(),
+		lane INTEGER DEFAULT 0,
+		source TEXT DEFAULT ''::text,
+		race_id INTEGER DEFAULT 0,
+		version INTEGER DEFAULT 1,
+		superseded_at TIMESTAMPTZ,
+		UNIQUE (regatta_id, race_id, bib_num, version)
 	);`,
+	// a bib has one current result in each race, the superseded ones are its history
+	`CREATE UNIQUE INDEX results_current ON Results (regatta_id, race_id, bib_num)
+		WHERE superseded_at IS NULL;`,
 	// "CREATE INDEX ON Results (bib_num);",
 	// `CREATE OR REPLACE FUNCTION notify_results() RETURNS TRIGGER AS $$
 	//  BEGIN
@@ -46,9 +59,20 @@ type Result struct {
 	Name     string        `db:"name"`
 	BibNum   int           `db:"bib_num"`
 	Class    string        `db:"class"`
+	Lane     int           `db:"lane"` // 0 if the results file doesn't say
+	RaceName string        `db:"-"`    // the race named in the results file, if any
 
 	// Not used by the Venue racing app
-	Official *bool `db:"official"`
+	RegattaID  int       `db:"regatta_id"`
+	RaceID     int       `db:"race_id"` // the race the results file is from, 0 if unknown
+	Official   *bool     `db:"official"`
+	DQ         bool      `db:"dq"` // disqualified by a protest ruling
+	ImportedAt time.Time `db:"imported_at"`
+	Source     string    `db:"source"` // name of the results file
+
+	// A corrected result supersedes the current one, and gets the next version
+	Version      int        `db:"version"`
+	SupersededAt *time.Time `db:"superseded_at"` // nil for the current result
 }
 
 //ReadResults reads the race results from the specified io.Reader and appends them to the
@@ -68,7 +92,10 @@ func ReadResults(results *[]Result, reader io.Reader) error {
 		return fmt.Errorf("found version %v results -- this software only knows version 103", ver)
 	}
 
-	scanner.Scan() // Skip blank line
+	// the race name, the line is blank if the venue racing software didn't write one
+	scanner.Scan()
+	raceName := strings.TrimSpace(scanner.Text())
+
 	scanner.Scan() // Skip headers line
 
 	lineNumber := 5
@@ -123,6 +150,12 @@ func ReadResults(results *[]Result, reader io.Reader) error {
 			AvgPace:  avgPace,
 			BibNum:   bibNum,
 			Class:    parts[7],
+			RaceName: raceName,
+		}
+
+		// the lane is left at 0 if it is blank
+		if lane, err := strconv.Atoi(strings.TrimSpace(parts[5])); err == nil {
+			result.Lane = lane
 		}
 		*results = append(*results, result)
 
@@ -147,14 +180,22 @@ func ReadResultsFromFile(filename string) ([]Result, error) {
 	return results, err
 }
 
+// insertResult adds a result, unless the bib already has a current one in the race
+const insertResult = `INSERT INTO Results(regatta_id, race_id, version, place, time, avg_pace,
+			distance, name, bib_num, class, lane, dq, source) 
+		VALUES(:regatta_id, :race_id, :version, :place, :time, :avg_pace,
+			:distance, :name, :bib_num, :class, :lane, :dq, :source)
+		ON CONFLICT (regatta_id, race_id, bib_num) WHERE superseded_at IS NULL
+		DO NOTHING;`
+
 // Insert will insert the result into the specfied database
-// Ignores conflict if bibnum already exists
+// Ignores conflict if the bib already has a current result in the race
 // Returns true if result was inserted
 func (result Result) Insert(db *sqlx.DB) (bool, error) {
-	sql := `INSERT INTO Results(place, time, avg_pace, distance, name, bib_num, class) 
-			VALUES(:place, :time, :avg_pace, :distance, :name, :bib_num, :class)
-			ON CONFLICT (bib_num)
-			DO NOTHING;`
+	sql := insertResult
+
+	result.RegattaID = RegattaID
+	result.Version = 1
 
 	res, err := db.NamedExec(sql, &result)
 	if err != nil {
@@ -165,8 +206,207 @@ func (result Result) Insert(db *sqlx.DB) (bool, error) {
 	return (num == 1), nil
 }
 
+// resultColumns are the columns read into a Result
+const resultColumns = `place, time, avg_pace, distance, name, bib_num, class, lane, official, dq,
+	imported_at, source, race_id, version, superseded_at`
+
+// entryResult joins each entry to one current result, the one from the race
+// the entry is scheduled in, or else the latest.  A bib can have results in
+// more than one race, such as when it raced in the wrong race.
+const entryResult = `JOIN LATERAL (
+		SELECT * FROM results
+		WHERE results.regatta_id = entries.regatta_id AND results.bib_num = entries.bib_num
+			AND results.superseded_at IS NULL
+		ORDER BY results.race_id = entries.race_id DESC, results.imported_at DESC, results.id DESC
+		LIMIT 1) results ON true`
+
+// ResultKey identifies a current result, a bib has one in each race it raced
+type ResultKey struct {
+	RaceID int
+	BibNum int
+}
+
+// Key returns the key of the result
+func (result Result) Key() ResultKey {
+	return ResultKey{RaceID: result.RaceID, BibNum: result.BibNum}
+}
+
+// CurrentResults are the current results, keyed by race and bib number
+type CurrentResults map[ResultKey]Result
+
+// InRace returns the bib's result in the race.  A result from a results file
+// that didn't match a scheduled race is used if there is none.
+func (results CurrentResults) InRace(raceID int, bibNum int) (Result, bool) {
+	if result, ok := results[ResultKey{RaceID: raceID, BibNum: bibNum}]; ok {
+		return result, true
+	}
+	result, ok := results[ResultKey{BibNum: bibNum}]
+	return result, ok
+}
+
+// LoadAllResults returns every current result
+func LoadAllResults(db DB) (CurrentResults, error) {
+	var results []Result
+	err := db.Select(&results, `SELECT `+resultColumns+`
+		FROM results WHERE regatta_id=$1 AND superseded_at IS NULL`, RegattaID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(CurrentResults, len(results))
+	for _, result := range results {
+		current[result.Key()] = result
+	}
+	return current, nil
+}
+
+// LoadResultByBib returns the bib's current result in the race.  If raceID is
+// 0, it returns the bib's only current result, and it is an error if the bib
+// has results in more than one race.
+func LoadResultByBib(db DB, bibNum int, raceID int) (Result, error) {
+	var results []Result
+	var err error
+	if raceID == 0 {
+		err = db.Select(&results, `SELECT `+resultColumns+`
+			FROM results WHERE regatta_id=$1 AND bib_num=$2 AND superseded_at IS NULL
+			ORDER BY race_id`, RegattaID, bibNum)
+	} else {
+		err = db.Select(&results, `SELECT `+resultColumns+`
+			FROM results WHERE regatta_id=$1 AND bib_num=$2 AND race_id=$3 AND superseded_at IS NULL`,
+			RegattaID, bibNum, raceID)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch {
+	case len(results) == 0 && raceID != 0:
+		return Result{}, fmt.Errorf("there is no result for bib number %d in race %d", bibNum, raceID)
+	case len(results) == 0:
+		return Result{}, fmt.Errorf("there is no result for bib number %d", bibNum)
+	case len(results) > 1:
+		var races []string
+		for _, result := range results {
+			races = append(races, strconv.Itoa(result.RaceID))
+		}
+		return Result{}, fmt.Errorf("bib number %d has results in races %s, say which race", bibNum, strings.Join(races, ", "))
+	}
+	return results[0], nil
+}
+
+// SetTime corrects the finishing time of the result.  The average pace
+// (per 500m) is recalculated from the new time.  The corrected result
+// supersedes the old one, so it shows in the bib's history.
+func (result *Result) SetTime(db DB, finishTime time.Duration) error {
+	return result.correct(db, func(r *Result) {
+		r.Time = finishTime
+		if r.Distance > 0 {
+			r.AvgPace = finishTime * 500 / time.Duration(r.Distance)
+		}
+	})
+}
+
+// SetDQ disqualifies the result, or reinstates it.  The new result
+// supersedes the old one, so it shows in the bib's history.
+func (result *Result) SetDQ(db DB, dq bool) error {
+	return result.correct(db, func(r *Result) {
+		r.DQ = dq
+	})
+}
+
+// correct supersedes the result with a copy that has the change made to it.
+// It is an error to change the result of a bib in a certified event.
+func (result *Result) correct(db DB, change func(r *Result)) error {
+	corrected := *result
+	change(&corrected)
+
+	err := Transact(db, func(tx *sqlx.Tx) error {
+		if err := lockResult(tx, result.BibNum); err != nil {
+			return err
+		}
+		return corrected.Supersede(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	*result = corrected
+	return nil
+}
+
+// lockResult locks the event of the bib until the transaction ends, so it
+// can't be certified while the bib's result is changed.  It is an error if
+// the event is already certified.
+func lockResult(tx *sqlx.Tx, bibNum int) error {
+	var eventID int
+	err := tx.Get(&eventID, "SELECT event_id FROM entries WHERE regatta_id=$1 AND bib_num=$2", RegattaID, bibNum)
+	if err == sql.ErrNoRows {
+		return nil // there is no event to lock
+	} else if err != nil {
+		return err
+	}
+
+	if err := (Event{ID: eventID}).Lock(tx); err != nil {
+		return err
+	}
+
+	locked, err := CertifiedBibs(tx)
+	if err != nil {
+		return err
+	}
+	if locked[bibNum] {
+		return fmt.Errorf("bib %d is in a certified event, uncertify the event first", bibNum)
+	}
+	return nil
+}
+
+// Same reports if the result is what the venue racing software reported for
+// other.  Results imported before races were recorded match any race.
+func (result Result) Same(other Result) bool {
+	sameRace := result.RaceID == other.RaceID || result.RaceID == 0 || other.RaceID == 0
+	return result.BibNum == other.BibNum && sameRace &&
+		result.Place == other.Place &&
+		result.Time == other.Time &&
+		result.Distance == other.Distance &&
+		result.Lane == other.Lane &&
+		result.Name == other.Name
+}
+
+// Supersede replaces the bib's current result in the race with this one, and
+// sets its version.  The old result is kept, superseded, so the history of the
+// bib's results can be queried.
+func (result *Result) Supersede(db DB) error {
+	return Transact(db, func(tx *sqlx.Tx) error {
+		var versions []int
+		err := tx.Select(&versions, `UPDATE results SET superseded_at=now()
+			WHERE regatta_id=$1 AND race_id=$2 AND bib_num=$3 AND superseded_at IS NULL
+			RETURNING version`, RegattaID, result.RaceID, result.BibNum)
+		if err != nil {
+			return err
+		}
+
+		result.RegattaID = RegattaID
+		result.Version = 1
+		for _, v := range versions {
+			result.Version = v + 1
+		}
+
+		_, err = tx.NamedExec(insertResult, result)
+		return err
+	})
+}
+
+// LoadResultVersions returns every version of a bib's results, by race and
+// oldest first
+func LoadResultVersions(db *sqlx.DB, bibNum int) (results []Result, err error) {
+	err = db.Select(&results, `SELECT `+resultColumns+`
+		FROM results WHERE regatta_id=$1 AND bib_num=$2
+		ORDER BY race_id, version, imported_at`, RegattaID, bibNum)
+	return
+}
+
 // NotifyResults will send the 'results' notification to the DB
-func NotifyResults(db *sqlx.DB) error {
+func NotifyResults(db DB) error {
 	_, err := db.Exec("NOTIFY results;")
 	return err
 }
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetDQ(t *testing.T) {
+	db := testDB(t)
+	insertTestEntry(t, db, 101)
+
+	result := Result{BibNum: 101, Name: "Smith", Time: 7 * time.Minute, Lane: 1}
+	if ok, err := result.Insert(db); err != nil || !ok {
+		t.Fatalf("inserting the first result: %v, %v", ok, err)
+	}
+	if err := result.SetDQ(db, true); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := LoadResultByBib(db, 101, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.DQ || current.Version != 2 || current.Time != result.Time {
+		t.Errorf("after the DQ the current result is v%d, dq %v, %v", current.Version, current.DQ, current.Time)
+	}
+
+	// reinstating is another version, so the DQ stays in the history
+	if err := current.SetDQ(db, false); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := LoadResultVersions(db, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("got %d versions, want 3", len(versions))
+	}
+	for i, v := range versions {
+		if current := i == len(versions)-1; current != (v.SupersededAt == nil) {
+			t.Errorf("v%d superseded at %v", v.Version, v.SupersededAt)
+		}
+	}
+	if versions[2].DQ {
+		t.Error("the reinstated result is still disqualified")
+	}
+
+	// the results of a certified event are locked
+	if err := (Certification{EventID: 0, Certified: true}).Insert(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := current.SetDQ(db, true); err == nil {
+		t.Error("disqualified a bib in a certified event")
+	}
+	if err := current.SetTime(db, 6*time.Minute); err == nil {
+		t.Error("adjusted a bib in a certified event")
+	}
+}
+
+func TestResultsInTwoRaces(t *testing.T) {
+	db := testDB(t)
+	insertTestEntry(t, db, 101)
+
+	// bib 101 raced in race 3, and by mistake in race 4
+	for _, raceID := range []int{3, 4} {
+		result := Result{RaceID: raceID, BibNum: 101, Name: "Smith", Time: time.Duration(raceID) * time.Minute}
+		if ok, err := result.Insert(db); err != nil || !ok {
+			t.Fatalf("inserting the result in race %d: %v, %v", raceID, ok, err)
+		}
+	}
+
+	if _, err := LoadResultByBib(db, 101, 0); err == nil {
+		t.Error("loaded one result for a bib with results in two races")
+	}
+
+	inRace4, err := LoadResultByBib(db, 101, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inRace4.SetTime(db, 6*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := LoadAllResults(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 2 {
+		t.Fatalf("got %d current results, want 2", len(current))
+	}
+	if r := current[ResultKey{RaceID: 3, BibNum: 101}]; r.Time != 3*time.Minute || r.Version != 1 {
+		t.Errorf("the result in race 3 is v%d %v, want it unchanged", r.Version, r.Time)
+	}
+	if r := current[ResultKey{RaceID: 4, BibNum: 101}]; r.Time != 6*time.Minute || r.Version != 2 {
+		t.Errorf("the result in race 4 is v%d %v, want v2 6m0s", r.Version, r.Time)
+	}
+}